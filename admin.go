@@ -0,0 +1,154 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+const adminServersPath = "/servers/"
+
+// adminHandler serves the admin API on its own port, only to requests bearing the admin token: GET /pools lists the
+// pools of servers, GET /callbacks/failed the callbacks that could not be delivered, GET /logs/{id} the logs of a
+// passed through request, POST /pause, /resume and /resize?servers={n} change a pool, POST /servers/{port}/drain and
+// /servers/{port}/restart a server, and POST /reload replaces all servers like SIGHUP. Pool changes apply to the
+// function named by ?function= if given, and otherwise to all functions, or the default function for /resize.
+type adminHandler struct {
+	router    funky.Router
+	functions map[string]funky.Router
+	notifier  *funky.WebhookNotifier
+	proxyLogs *funky.InvocationStore
+	token     string
+}
+
+func (h adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="funky admin"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == "/pools" {
+		h.writePools(w)
+		return
+	}
+	if r.Method == http.MethodGet && r.URL.Path == "/callbacks/failed" {
+		// the failed deliveries hold the results of the invocations, which only their callers may see
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.notifier.Failed())
+		return
+	}
+	if r.Method == http.MethodGet && h.proxyLogs != nil && strings.HasPrefix(r.URL.Path, proxyLogsPath) {
+		h.writeProxyLogs(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	switch {
+	case r.URL.Path == "/reload":
+		err = h.router.Reload()
+	case r.URL.Path == "/pause" || r.URL.Path == "/resume" || r.URL.Path == "/resize":
+		err = h.pool(r)
+	case strings.HasPrefix(r.URL.Path, adminServersPath):
+		err = h.server(r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePools(w)
+}
+
+// writeProxyLogs writes the logs and error of the passed through request whose ID ends the path
+func (h adminHandler) writeProxyLogs(w http.ResponseWriter, r *http.Request) {
+	inv, ok := h.proxyLogs.Get(strings.TrimPrefix(r.URL.Path, proxyLogsPath))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inv.Result)
+}
+
+// pool pauses, resumes or resizes the pool of the requested function
+func (h adminHandler) pool(r *http.Request) error {
+	router := h.router
+	if name := r.URL.Query().Get("function"); name != "" {
+		var ok bool
+		if router, ok = h.functions[name]; !ok {
+			return funky.UnknownFunctionError(name)
+		}
+	}
+
+	switch r.URL.Path {
+	case "/pause":
+		router.Pause()
+	case "/resume":
+		router.Resume()
+	default:
+		numServers, err := strconv.Atoi(r.URL.Query().Get("servers"))
+		if err != nil {
+			return funky.IllegalArgumentError("servers")
+		}
+		return router.Resize(numServers)
+	}
+	return nil
+}
+
+// server drains or restarts the server on the port in the path
+func (h adminHandler) server(r *http.Request) error {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, adminServersPath), "/")
+	if len(parts) != 2 {
+		return funky.IllegalArgumentError(r.URL.Path)
+	}
+	port, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return funky.IllegalArgumentError("port")
+	}
+
+	switch parts[1] {
+	case "drain":
+		return h.router.DrainServer(uint16(port))
+	case "restart":
+		return h.router.RestartServer(uint16(port))
+	}
+	return funky.IllegalArgumentError(parts[1])
+}
+
+func (h adminHandler) writePools(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.router.Pools())
+}
+
+func (h adminHandler) writeError(w http.ResponseWriter, err error) {
+	status, errorType := http.StatusInternalServerError, funky.SystemError
+	switch err.(type) {
+	case funky.IllegalArgumentError:
+		status, errorType = http.StatusBadRequest, funky.InputError
+	case funky.UnknownServerError, funky.UnknownFunctionError:
+		status, errorType = http.StatusNotFound, funky.InputError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(funky.NewErrorMessage(errorType, err))
+}
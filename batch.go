@@ -0,0 +1,104 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+// batchHandler serves POST /batch, invoking the function on every request of a JSON array
+type batchHandler struct {
+	router      funky.Router
+	concurrency int
+	maxItems    int
+	maxBytes    int64
+}
+
+func (h batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := r.Body
+	if h.maxBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, h.maxBytes)
+	}
+
+	inputs, err := decodeBatch(body, h.maxItems)
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("stream") == "true" || strings.Contains(r.Header.Get("Accept"), funky.NDJSONContentType) {
+		h.stream(w, inputs)
+		return
+	}
+
+	results := make([]*funky.Message, len(inputs))
+	funky.Batch(h.router, inputs, h.concurrency, func(i int, msg *funky.Message) {
+		results[i] = msg
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// stream writes every result as a line of NDJSON as soon as it completes
+func (h batchHandler) stream(w http.ResponseWriter, inputs []*funky.Request) {
+	w.Header().Set("Content-Type", funky.NDJSONContentType)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	funky.Batch(h.router, inputs, h.concurrency, func(i int, msg *funky.Message) {
+		encoder.Encode(funky.BatchResult{Index: i, Message: msg})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+}
+
+// decodeBatch reads the JSON array of requests in body one item at a time, so that it stops as soon as the batch
+// holds more than maxItems
+func decodeBatch(body io.Reader, maxItems int) ([]*funky.Request, error) {
+	decoder := json.NewDecoder(body)
+	if t, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("Invalid Input: %s", err)
+	} else if t != json.Delim('[') {
+		return nil, errors.New("Invalid Input: expected a JSON array of requests")
+	}
+
+	inputs := []*funky.Request{}
+	for decoder.More() {
+		if len(inputs) == maxItems {
+			return nil, fmt.Errorf("Invalid Input: batch exceeds the limit of %d items", maxItems)
+		}
+
+		var input *funky.Request
+		if err := decoder.Decode(&input); err != nil {
+			return nil, fmt.Errorf("Invalid Input: %s", err)
+		}
+		inputs = append(inputs, input)
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("Invalid Input: %s", err)
+	}
+	return inputs, nil
+}
+
+func writeBatchError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(funky.NewErrorMessage(funky.InputError, err))
+}
@@ -0,0 +1,42 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+func TestBatchRejectsInvalidBodies(t *testing.T) {
+	h := batchHandler{concurrency: 1, maxItems: 2, maxBytes: 64}
+
+	tests := []struct {
+		body    string
+		message string
+	}{
+		// the items past the limit are not read, so the trailing garbage is never reached
+		{`[{}, {}, {}, not json`, "batch exceeds the limit of 2 items"},
+		{`[{"payload": "` + strings.Repeat("x", 64) + `"}]`, "request body too large"},
+		{`{"payload": "one"}`, "expected a JSON array of requests"},
+		{`[{}`, "unexpected end of JSON input"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(test.body)))
+
+		var msg funky.Message
+		json.NewDecoder(w.Body).Decode(&msg)
+		if w.Code != http.StatusBadRequest || msg.Context == nil || msg.Context.Error == nil ||
+			!strings.Contains(msg.Context.Error.Message, test.message) {
+			t.Errorf("Batch %q answered %d %+v, expected an error containing %q", test.body, w.Code, msg.Context, test.message)
+		}
+	}
+}
@@ -0,0 +1,763 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+const (
+	isolateEnvVar        = "ISOLATE_SERVERS"
+	isolateNetworkEnvVar = "ISOLATE_NETWORK"
+	socketDirEnvVar      = "SOCKET_DIR"
+	envAllowEnvVar       = "ENV_ALLOW"
+	envDenyEnvVar        = "ENV_DENY"
+	secretsPathEnvVar    = "SECRETS_PATH"
+	secretsModeEnvVar    = "SECRETS_MODE"
+	secretsReloadEnvVar  = "SECRETS_RELOAD_INTERVAL"
+	redactEnvVar         = "REDACT"
+	redactPatternsEnvVar = "REDACT_PATTERNS_FILE"
+	logMaxLineEnvVar     = "LOG_MAX_LINE_LENGTH"
+	logMaxLinesEnvVar    = "LOG_MAX_LINES"
+	logMaxBytesEnvVar    = "LOG_MAX_BYTES"
+	sinkStdoutEnvVar     = "LOG_SINK_STDOUT"
+	sinkFileEnvVar       = "LOG_SINK_FILE"
+	sinkFileMaxEnvVar    = "LOG_SINK_FILE_MAX_BYTES"
+	sinkFileBackupEnvVar = "LOG_SINK_FILE_BACKUPS"
+	sinkSyslogEnvVar     = "LOG_SINK_SYSLOG"
+	logFormatEnvVar      = "LOG_FORMAT"
+	asyncTTLEnvVar       = "ASYNC_TTL"
+	asyncMaxEnvVar       = "ASYNC_MAX_INVOCATIONS"
+	asyncJournalEnvVar   = "ASYNC_JOURNAL"
+	callbackSecretEnvVar = "CALLBACK_SECRET"
+	callbackTriesEnvVar  = "CALLBACK_MAX_ATTEMPTS"
+	callbackDelayEnvVar  = "CALLBACK_BACKOFF"
+	callbackTimeEnvVar   = "CALLBACK_TIMEOUT"
+	callbackFailedEnvVar = "CALLBACK_MAX_FAILED"
+	callbackAllowEnvVar  = "CALLBACK_ALLOWED_NETWORKS"
+	batchConcurrEnvVar   = "BATCH_CONCURRENCY"
+	batchMaxEnvVar       = "BATCH_MAX_ITEMS"
+	batchBytesEnvVar     = "BATCH_MAX_BYTES"
+	uploadDirEnvVar      = "UPLOAD_DIR"
+	maxBodyEnvVar        = "MAX_BODY_BYTES"
+	httpContextEnvVar    = "HTTP_CONTEXT"
+	httpHeadersEnvVar    = "HTTP_CONTEXT_HEADERS"
+	proxyEnvVar          = "PROXY_MODE"
+	proxyTimeoutEnvVar   = "PROXY_TIMEOUT"
+	proxyLogsEnvVar      = "PROXY_LOGS"
+	statusCodesEnvVar    = "ERROR_STATUS_CODES"
+	functionStatusEnvVar = "FUNCTION_ERROR_STATUS"
+	maxPendingEnvVar     = "MAX_PENDING"
+	inputSchemaEnvVar    = "INPUT_SCHEMA"
+	outputSchemaEnvVar   = "OUTPUT_SCHEMA"
+	cacheTTLEnvVar       = "CACHE_TTL"
+	cacheMaxBytesEnvVar  = "CACHE_MAX_BYTES"
+	cacheKeyEnvVar       = "CACHE_KEY_CONTEXT"
+	coalesceEnvVar       = "COALESCE"
+	coalesceKeyEnvVar    = "COALESCE_KEY_CONTEXT"
+	retryAttemptsEnvVar  = "RETRY_MAX_ATTEMPTS"
+	retryBackoffEnvVar   = "RETRY_BACKOFF"
+	retryTimeoutsEnvVar  = "RETRY_TIMEOUTS"
+	retryAttemptEnvVar   = "RETRY_ATTEMPT_TIMEOUT"
+	breakerRateEnvVar    = "BREAKER_FAILURE_RATE"
+	breakerWindowEnvVar  = "BREAKER_WINDOW"
+	breakerMinEnvVar     = "BREAKER_MIN_REQUESTS"
+	breakerOpenEnvVar    = "BREAKER_OPEN_DURATION"
+	breakerProbesEnvVar  = "BREAKER_PROBES"
+	warmUpPayloadEnvVar  = "WARMUP_PAYLOAD"
+	warmUpFileEnvVar     = "WARMUP_FILE"
+	warmUpTimeoutEnvVar  = "WARMUP_TIMEOUT"
+	warmUpAttemptsEnvVar = "WARMUP_MAX_ATTEMPTS"
+	scaleToZeroEnvVar    = "SCALE_TO_ZERO"
+	idleTimeoutEnvVar    = "IDLE_TIMEOUT"
+	functionsEnvVar      = "FUNCTIONS_CONFIG"
+	reloadWatchEnvVar    = "RELOAD_WATCH_DIR"
+	reloadDebounceEnvVar = "RELOAD_DEBOUNCE"
+	reloadReadyEnvVar    = "RELOAD_READY_TIMEOUT"
+	maxServersEnvVar     = "MAX_SERVERS"
+	adminPortEnvVar      = "ADMIN_PORT"
+	adminTokenEnvVar     = "ADMIN_TOKEN"
+	priorityResEnvVar    = "PRIORITY_RESERVED"
+	priorityWaitEnvVar   = "PRIORITY_MAX_WAIT"
+)
+
+const (
+	defaultAsyncTTL         = time.Hour
+	defaultAsyncMax         = 1000
+	defaultCallbackTries    = 5
+	defaultCallbackBackoff  = time.Second
+	defaultCallbackTimeout  = 10 * time.Second
+	defaultCallbackFailed   = 100
+	defaultBatchMaxItems    = 1000
+	defaultBatchMaxBytes    = 32 * 1024 * 1024
+	defaultSinkFileMaxBytes = 10 * 1024 * 1024
+	defaultSinkFileBackups  = 5
+	defaultCacheMaxBytes    = 64 * 1024 * 1024
+	defaultRetryAttempts    = 1
+	defaultRetryBackoff     = 100 * time.Millisecond
+	defaultBreakerWindow    = 20
+	defaultBreakerMin       = 10
+	defaultBreakerOpen      = 30 * time.Second
+	defaultBreakerProbes    = 1
+	defaultWarmUpTimeout    = 30 * time.Second
+	defaultWarmUpAttempts   = 3
+	defaultIdleTimeout      = 5 * time.Minute
+	defaultReloadDebounce   = 500 * time.Millisecond
+	defaultReloadReady      = 30 * time.Second
+)
+
+const defaultSecretsReload = 10 * time.Second
+
+// config the settings funky is started with, read from the environment
+type config struct {
+	server    funky.ServerOptions
+	router    funky.RouterOptions
+	secrets   secretsConfig
+	async     asyncConfig
+	callbacks callbackConfig
+	batch     batchConfig
+	ingest    ingestConfig
+
+	httpContext bool
+	httpHeaders []string
+
+	proxy     bool
+	proxyLogs bool
+
+	statusCodes         bool
+	functionErrorStatus int
+
+	inputSchema  *funky.Schema
+	outputSchema *funky.Schema
+
+	cache funky.CacheOptions
+
+	coalesce     bool
+	coalesceKeys []string
+
+	breaker funky.BreakerOptions
+
+	functions []functionConfig
+
+	reload reloadConfig
+
+	admin adminConfig
+}
+
+// secretsConfig the loaded secrets and how often they are checked for changes, not at all if not positive
+type secretsConfig struct {
+	secrets  *funky.Secrets
+	interval time.Duration
+}
+
+// adminConfig the settings of the admin API
+type adminConfig struct {
+	port  string
+	token string
+}
+
+// reloadConfig the settings for reloading servers when the function code changes
+type reloadConfig struct {
+	watchDir string
+	debounce time.Duration
+}
+
+// functionConfig the settings of a function hosted besides, or instead of, SERVER_CMD
+type functionConfig struct {
+	name        string
+	command     string
+	servers     int
+	maxServers  int
+	timeout     time.Duration
+	onDemand    bool
+	idleTimeout time.Duration
+}
+
+// ingestConfig the settings for decoding request bodies
+type ingestConfig struct {
+	spoolDir string
+	maxBytes int64
+}
+
+// batchConfig the settings of the batch endpoint
+type batchConfig struct {
+	concurrency int
+	maxItems    int
+	maxBytes    int64
+}
+
+// callbackConfig the settings for delivering the results of asynchronous invocations to callback URLs
+type callbackConfig struct {
+	secret      []byte
+	maxAttempts int
+	backoff     time.Duration
+	timeout     time.Duration
+	maxFailed   int
+	allowed     []*net.IPNet
+}
+
+// asyncConfig the settings of the store for asynchronous invocations
+type asyncConfig struct {
+	ttl        time.Duration
+	maxEntries int
+	journal    string
+}
+
+func envBool(name string) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("Unable to parse %s environment variable", name)
+	}
+	return b, nil
+}
+
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("Unable to parse %s environment variable", name)
+	}
+	return i, nil
+}
+
+func envFloat(name string, def float64) (float64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("Unable to parse %s environment variable", name)
+	}
+	return f, nil
+}
+
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to parse %s environment variable", name)
+	}
+	return d, nil
+}
+
+func envList(name string) []string {
+	list := []string{}
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// envWarmUpPayloads returns the payload in WARMUP_PAYLOAD followed by the payloads in the file named by WARMUP_FILE,
+// a sequence of JSON values such as one per line
+func envWarmUpPayloads() ([]interface{}, error) {
+	payloads := []interface{}{}
+	if v := os.Getenv(warmUpPayloadEnvVar); v != "" {
+		var payload interface{}
+		if err := json.Unmarshal([]byte(v), &payload); err != nil {
+			return nil, fmt.Errorf("Unable to parse %s environment variable: %s", warmUpPayloadEnvVar, err)
+		}
+		payloads = append(payloads, payload)
+	}
+
+	path := os.Getenv(warmUpFileEnvVar)
+	if path == "" {
+		return payloads, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s %s: %s", warmUpFileEnvVar, path, err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var payload interface{}
+		if err := decoder.Decode(&payload); err == io.EOF {
+			return payloads, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s %s: %s", warmUpFileEnvVar, path, err)
+		}
+		payloads = append(payloads, payload)
+	}
+}
+
+// envReserved returns the servers reserved for priorities in PRIORITY_RESERVED, a list of priority=servers pairs
+// such as "10=2,5=1"
+func envReserved() (map[int]int, error) {
+	reserved := map[int]int{}
+	for _, pair := range envList(priorityResEnvVar) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid %s environment variable: %s", priorityResEnvVar, pair)
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s environment variable: %s", priorityResEnvVar, pair)
+		}
+		servers, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || servers < 0 {
+			return nil, fmt.Errorf("Invalid %s environment variable: %s", priorityResEnvVar, pair)
+		}
+		reserved[priority] += servers
+	}
+	return reserved, nil
+}
+
+// functionNamePattern matches the names of functions, which are used in paths
+var functionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// envFunctions returns the functions in the JSON file named by FUNCTIONS_CONFIG, sorted by name. The file maps
+// function names to their settings, e.g. {"resize": {"command": "python3 resize.py", "servers": 2, "timeout": "10s"}}.
+func envFunctions(defaultIdleTimeout time.Duration) ([]functionConfig, error) {
+	path := os.Getenv(functionsEnvVar)
+	if path == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s %s: %s", functionsEnvVar, path, err)
+	}
+
+	var entries map[string]struct {
+		Command     string `json:"command"`
+		Servers     int    `json:"servers"`
+		MaxServers  int    `json:"maxServers"`
+		Timeout     string `json:"timeout"`
+		ScaleToZero bool   `json:"scaleToZero"`
+		IdleTimeout string `json:"idleTimeout"`
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s %s: %s", functionsEnvVar, path, err)
+	}
+
+	functions := []functionConfig{}
+	for name, e := range entries {
+		if !functionNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("Invalid function name in %s: %q", functionsEnvVar, name)
+		}
+		if strings.TrimSpace(e.Command) == "" {
+			return nil, fmt.Errorf("Function %s in %s has no command", name, functionsEnvVar)
+		}
+
+		fn := functionConfig{
+			name:        name,
+			command:     e.Command,
+			servers:     e.Servers,
+			maxServers:  e.MaxServers,
+			onDemand:    e.ScaleToZero,
+			idleTimeout: defaultIdleTimeout,
+		}
+		if fn.servers < 1 {
+			fn.servers = 1
+		}
+		if e.Timeout != "" {
+			if fn.timeout, err = time.ParseDuration(e.Timeout); err != nil {
+				return nil, fmt.Errorf("Invalid timeout of function %s in %s: %s", name, functionsEnvVar, err)
+			}
+		}
+		if e.IdleTimeout != "" {
+			if fn.idleTimeout, err = time.ParseDuration(e.IdleTimeout); err != nil {
+				return nil, fmt.Errorf("Invalid idleTimeout of function %s in %s: %s", name, functionsEnvVar, err)
+			}
+		}
+		functions = append(functions, fn)
+	}
+
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].name < functions[j].name
+	})
+	return functions, nil
+}
+
+// envSchema returns the JSON Schema in the file named by the environment variable, nil if it is not set
+func envSchema(name string) (*funky.Schema, error) {
+	path := os.Getenv(name)
+	if path == "" {
+		return nil, nil
+	}
+
+	schema, err := funky.LoadSchema(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load schema %s from %s: %s", name, path, err)
+	}
+	return schema, nil
+}
+
+func loadConfig() (*config, error) {
+	c := &config{}
+
+	isolate, err := envBool(isolateEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	isolateNetwork, err := envBool(isolateNetworkEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	if isolate || isolateNetwork {
+		c.server.Isolation = &funky.IsolationConfig{
+			Network:   isolateNetwork,
+			SocketDir: os.Getenv(socketDirEnvVar),
+		}
+	}
+
+	c.server.Environment = funky.EnvironmentFilter{
+		Allow: envList(envAllowEnvVar),
+		Deny:  envList(envDenyEnvVar),
+	}
+
+	if path := os.Getenv(secretsPathEnvVar); path != "" {
+		secrets, err := funky.NewSecrets(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load secrets from %s: %s", path, err)
+		}
+
+		c.secrets.secrets = secrets
+		if c.secrets.interval, err = envDuration(secretsReloadEnvVar, defaultSecretsReload); err != nil {
+			return nil, err
+		}
+
+		switch mode := os.Getenv(secretsModeEnvVar); mode {
+		case "", "env":
+			c.server.Secrets = secrets
+		case "context":
+			c.router.Secrets = secrets
+		default:
+			return nil, fmt.Errorf("Invalid %s environment variable: %s", secretsModeEnvVar, mode)
+		}
+	}
+
+	if c.server.LogLimits.MaxLineLength, err = envInt(logMaxLineEnvVar, 0); err != nil {
+		return nil, err
+	}
+	if c.server.LogLimits.MaxLines, err = envInt(logMaxLinesEnvVar, 0); err != nil {
+		return nil, err
+	}
+	if c.server.LogLimits.MaxBytes, err = envInt(logMaxBytesEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	switch format := os.Getenv(logFormatEnvVar); format {
+	case "", "legacy":
+	case "structured":
+		c.router.StructuredLogs = true
+	default:
+		return nil, fmt.Errorf("Invalid %s environment variable: %s", logFormatEnvVar, format)
+	}
+
+	redact, err := envBool(redactEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	if redact {
+		patterns, err := redactionPatterns(os.Getenv(redactPatternsEnvVar))
+		if err != nil {
+			return nil, err
+		}
+
+		secrets := c.server.Secrets
+		if secrets == nil {
+			secrets = c.router.Secrets
+		}
+		c.router.Redactor = funky.NewRedactor(secrets, patterns)
+		c.server.Redactor = c.router.Redactor
+	}
+
+	if c.server.LogSink, err = logSink(); err != nil {
+		return nil, err
+	}
+
+	if c.async.ttl, err = envDuration(asyncTTLEnvVar, defaultAsyncTTL); err != nil {
+		return nil, err
+	}
+	if c.async.maxEntries, err = envInt(asyncMaxEnvVar, defaultAsyncMax); err != nil {
+		return nil, err
+	}
+	c.async.journal = os.Getenv(asyncJournalEnvVar)
+
+	c.callbacks.secret = []byte(os.Getenv(callbackSecretEnvVar))
+	if c.callbacks.maxAttempts, err = envInt(callbackTriesEnvVar, defaultCallbackTries); err != nil {
+		return nil, err
+	}
+	if c.callbacks.backoff, err = envDuration(callbackDelayEnvVar, defaultCallbackBackoff); err != nil {
+		return nil, err
+	}
+	if c.callbacks.timeout, err = envDuration(callbackTimeEnvVar, defaultCallbackTimeout); err != nil {
+		return nil, err
+	}
+	if c.callbacks.maxFailed, err = envInt(callbackFailedEnvVar, defaultCallbackFailed); err != nil {
+		return nil, err
+	}
+	for _, v := range envList(callbackAllowEnvVar) {
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s environment variable", callbackAllowEnvVar)
+		}
+		c.callbacks.allowed = append(c.callbacks.allowed, network)
+	}
+
+	if c.batch.concurrency, err = envInt(batchConcurrEnvVar, 0); err != nil {
+		return nil, err
+	}
+	if c.batch.maxItems, err = envInt(batchMaxEnvVar, defaultBatchMaxItems); err != nil {
+		return nil, err
+	}
+	batchBytes, err := envInt(batchBytesEnvVar, defaultBatchMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	c.batch.maxBytes = int64(batchBytes)
+
+	c.ingest.spoolDir = os.Getenv(uploadDirEnvVar)
+	maxBody, err := envInt(maxBodyEnvVar, 0)
+	if err != nil {
+		return nil, err
+	}
+	c.ingest.maxBytes = int64(maxBody)
+
+	if c.httpContext, err = envBool(httpContextEnvVar); err != nil {
+		return nil, err
+	}
+	c.httpHeaders = envList(httpHeadersEnvVar)
+
+	if c.proxy, err = envBool(proxyEnvVar); err != nil {
+		return nil, err
+	}
+	if c.proxyLogs, err = envBool(proxyLogsEnvVar); err != nil {
+		return nil, err
+	}
+	if c.router.ProxyTimeout, err = envDuration(proxyTimeoutEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if c.statusCodes, err = envBool(statusCodesEnvVar); err != nil {
+		return nil, err
+	}
+	if c.functionErrorStatus, err = envInt(functionStatusEnvVar, http.StatusInternalServerError); err != nil {
+		return nil, err
+	}
+	if c.functionErrorStatus < 400 || c.functionErrorStatus > 599 {
+		return nil, fmt.Errorf("Invalid %s environment variable: %d", functionStatusEnvVar, c.functionErrorStatus)
+	}
+	if c.router.MaxPending, err = envInt(maxPendingEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if c.inputSchema, err = envSchema(inputSchemaEnvVar); err != nil {
+		return nil, err
+	}
+	if c.outputSchema, err = envSchema(outputSchemaEnvVar); err != nil {
+		return nil, err
+	}
+
+	if c.cache.TTL, err = envDuration(cacheTTLEnvVar, 0); err != nil {
+		return nil, err
+	}
+	cacheMaxBytes, err := envInt(cacheMaxBytesEnvVar, defaultCacheMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.MaxBytes = int64(cacheMaxBytes)
+	c.cache.ContextKeys = envList(cacheKeyEnvVar)
+
+	if c.coalesce, err = envBool(coalesceEnvVar); err != nil {
+		return nil, err
+	}
+	c.coalesceKeys = envList(coalesceKeyEnvVar)
+
+	if c.router.Retry.MaxAttempts, err = envInt(retryAttemptsEnvVar, defaultRetryAttempts); err != nil {
+		return nil, err
+	}
+	if c.router.Retry.Backoff, err = envDuration(retryBackoffEnvVar, defaultRetryBackoff); err != nil {
+		return nil, err
+	}
+	if c.router.Retry.Timeouts, err = envBool(retryTimeoutsEnvVar); err != nil {
+		return nil, err
+	}
+	if c.router.Retry.AttemptTimeout, err = envDuration(retryAttemptEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if c.breaker.FailureRate, err = envFloat(breakerRateEnvVar, 0); err != nil {
+		return nil, err
+	}
+	if c.breaker.FailureRate > 1 {
+		return nil, fmt.Errorf("Invalid %s environment variable: %v", breakerRateEnvVar, c.breaker.FailureRate)
+	}
+	if c.breaker.Window, err = envInt(breakerWindowEnvVar, defaultBreakerWindow); err != nil {
+		return nil, err
+	}
+	if c.breaker.MinRequests, err = envInt(breakerMinEnvVar, defaultBreakerMin); err != nil {
+		return nil, err
+	}
+	if c.breaker.OpenDuration, err = envDuration(breakerOpenEnvVar, defaultBreakerOpen); err != nil {
+		return nil, err
+	}
+	if c.breaker.Probes, err = envInt(breakerProbesEnvVar, defaultBreakerProbes); err != nil {
+		return nil, err
+	}
+
+	if c.router.WarmUp.Payloads, err = envWarmUpPayloads(); err != nil {
+		return nil, err
+	}
+	if c.router.WarmUp.Timeout, err = envDuration(warmUpTimeoutEnvVar, defaultWarmUpTimeout); err != nil {
+		return nil, err
+	}
+	if c.router.WarmUp.MaxAttempts, err = envInt(warmUpAttemptsEnvVar, defaultWarmUpAttempts); err != nil {
+		return nil, err
+	}
+
+	if c.router.OnDemand, err = envBool(scaleToZeroEnvVar); err != nil {
+		return nil, err
+	}
+	if c.router.IdleTimeout, err = envDuration(idleTimeoutEnvVar, defaultIdleTimeout); err != nil {
+		return nil, err
+	}
+
+	if c.functions, err = envFunctions(c.router.IdleTimeout); err != nil {
+		return nil, err
+	}
+
+	c.reload.watchDir = os.Getenv(reloadWatchEnvVar)
+	if c.reload.debounce, err = envDuration(reloadDebounceEnvVar, defaultReloadDebounce); err != nil {
+		return nil, err
+	}
+	if c.router.ReloadReadyTimeout, err = envDuration(reloadReadyEnvVar, defaultReloadReady); err != nil {
+		return nil, err
+	}
+
+	if c.router.Priority.Reserved, err = envReserved(); err != nil {
+		return nil, err
+	}
+	if c.router.Priority.MaxWait, err = envDuration(priorityWaitEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if c.router.MaxServers, err = envInt(maxServersEnvVar, 0); err != nil {
+		return nil, err
+	}
+	c.admin.port = os.Getenv(adminPortEnvVar)
+	c.admin.token = os.Getenv(adminTokenEnvVar)
+	if c.admin.port != "" && c.admin.token == "" {
+		return nil, fmt.Errorf("%s is required with %s", adminTokenEnvVar, adminPortEnvVar)
+	}
+	if c.proxyLogs && c.admin.port == "" {
+		return nil, fmt.Errorf("%s is required with %s", adminPortEnvVar, proxyLogsEnvVar)
+	}
+
+	return c, nil
+}
+
+// logSink returns the configured sinks for function logs, nil if there are none
+func logSink() (funky.LogSink, error) {
+	sinks := funky.MultiSink{}
+
+	stdout, err := envBool(sinkStdoutEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	if stdout {
+		sinks = append(sinks, funky.NewStdoutSink())
+	}
+
+	if path := os.Getenv(sinkFileEnvVar); path != "" {
+		maxBytes, err := envInt(sinkFileMaxEnvVar, defaultSinkFileMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		backups, err := envInt(sinkFileBackupEnvVar, defaultSinkFileBackups)
+		if err != nil {
+			return nil, err
+		}
+		sink, err := funky.NewRotatingFileSink(path, int64(maxBytes), backups)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open log file %s: %s", path, err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if addr := os.Getenv(sinkSyslogEnvVar); addr != "" {
+		network, address := "", ""
+		if addr != "local" {
+			u, err := url.Parse(addr)
+			if err != nil || u.Scheme == "" {
+				return nil, fmt.Errorf("Invalid %s environment variable: %s", sinkSyslogEnvVar, addr)
+			}
+			network, address = u.Scheme, u.Host
+			if u.Host == "" {
+				address = u.Path
+			}
+		}
+		sink, err := funky.NewSyslogSink(network, address)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to connect to syslog at %s: %s", addr, err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return sinks, nil
+}
+
+// redactionPatterns returns the default patterns plus those in path, one regular expression per line
+func redactionPatterns(path string) ([]*regexp.Regexp, error) {
+	patterns := append([]*regexp.Regexp{}, funky.DefaultRedactionPatterns...)
+	if path == "" {
+		return patterns, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read redaction patterns: %s", err)
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redaction pattern %q: %s", line, err)
+		}
+		patterns = append(patterns, p)
+	}
+
+	return patterns, nil
+}
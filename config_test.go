@@ -0,0 +1,68 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+func TestLoadConfigIsolation(t *testing.T) {
+	tests := []struct {
+		env       map[string]string
+		isolation *funky.IsolationConfig
+		ok        bool
+	}{
+		{map[string]string{}, nil, true},
+		{map[string]string{isolateEnvVar: "false"}, nil, true},
+		{map[string]string{isolateEnvVar: "true"}, &funky.IsolationConfig{}, true},
+		{map[string]string{isolateNetworkEnvVar: "true"}, &funky.IsolationConfig{Network: true}, true},
+		{map[string]string{isolateNetworkEnvVar: "1", socketDirEnvVar: "/run/funky"}, &funky.IsolationConfig{Network: true, SocketDir: "/run/funky"}, true},
+		{map[string]string{isolateEnvVar: "yes please"}, nil, false},
+		{map[string]string{isolateNetworkEnvVar: "maybe"}, nil, false},
+	}
+
+	for _, test := range tests {
+		for _, name := range []string{isolateEnvVar, isolateNetworkEnvVar, socketDirEnvVar} {
+			os.Unsetenv(name)
+		}
+		for name, value := range test.env {
+			os.Setenv(name, value)
+		}
+
+		c, err := loadConfig()
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("Loading config from %v returned %v, expected ok %v", test.env, err, test.ok)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(c.server.Isolation, test.isolation) {
+			t.Errorf("Expected isolation %+v loading config from %v, got %+v", test.isolation, test.env, c.server.Isolation)
+		}
+	}
+
+	for _, name := range []string{isolateEnvVar, isolateNetworkEnvVar, socketDirEnvVar} {
+		os.Unsetenv(name)
+	}
+}
+
+func TestLoadConfigProxyLogsRequireAdmin(t *testing.T) {
+	defer os.Unsetenv(proxyLogsEnvVar)
+	defer os.Unsetenv(adminPortEnvVar)
+	defer os.Unsetenv(adminTokenEnvVar)
+
+	os.Setenv(proxyLogsEnvVar, "true")
+	if _, err := loadConfig(); err == nil {
+		t.Errorf("Expected %s without %s to be rejected", proxyLogsEnvVar, adminPortEnvVar)
+	}
+
+	os.Setenv(adminPortEnvVar, "8081")
+	os.Setenv(adminTokenEnvVar, "s3cr3t")
+	if _, err := loadConfig(); err != nil {
+		t.Errorf("Unexpected error loading config: %v", err)
+	}
+}
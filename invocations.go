@@ -0,0 +1,86 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+const (
+	invocationsPath = "/invocations"
+	callbackHeader  = "X-Callback-Url"
+)
+
+// invocationsHandler serves POST /invocations, asynchronously with ?async=true, and GET /invocations/{id}
+type invocationsHandler struct {
+	funkyHandler
+	async *funky.AsyncInvoker
+}
+
+func (h invocationsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, invocationsPath), "/")
+
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		if r.URL.Query().Get("async") == "true" {
+			h.submit(w, r)
+		} else {
+			h.funkyHandler.ServeHTTP(w, r)
+		}
+	case r.Method == http.MethodGet && id != "":
+		h.get(w, r, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h invocationsHandler) submit(w http.ResponseWriter, r *http.Request) {
+	body, cleanup, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+
+	if callback := r.Header.Get(callbackHeader); callback != "" {
+		if body.Context == nil {
+			body.Context = map[string]interface{}{}
+		}
+		if _, ok := body.Context[funky.CallbackContextKey]; !ok {
+			body.Context[funky.CallbackContextKey] = callback
+		}
+	}
+
+	inv, err := h.async.Submit(body, cleanup)
+	if err != nil {
+		cleanup()
+		status, errorType := http.StatusBadRequest, funky.InputError
+		if _, ok := err.(funky.QueueFullError); ok {
+			status, errorType = http.StatusTooManyRequests, funky.SystemError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(funky.NewErrorMessage(errorType, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", invocationsPath+"/"+inv.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(inv)
+}
+
+func (h invocationsHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+	inv, ok := h.async.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inv)
+}
@@ -13,6 +13,8 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/dispatchframework/funky/pkg/funky"
 )
@@ -23,32 +25,188 @@ const (
 	portEnvVar      = "PORT"
 )
 
+const functionsPath = "/functions/"
+
+// defaultFunction the name of the function run by SERVER_CMD, invoked by requests that do not name a function
+const defaultFunction = "default"
+
 type funkyHandler struct {
-	router funky.Router
+	router      funky.Router
+	ingester    *funky.Ingester
+	httpContext *funky.HTTPContext
+
+	// statusCodes answers failed invocations with the status code of their error instead of 200
+	statusCodes         bool
+	functionErrorStatus int
 }
 
 func (f funkyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var body funky.Request
-	err := json.NewDecoder(r.Body).Decode(&body)
+	body, cleanup, ok := f.decode(w, r)
+	if !ok {
+		return
+	}
+	defer cleanup()
+
+	if strings.Contains(r.Header.Get("Accept"), funky.NDJSONContentType) {
+		f.stream(w, body)
+		return
+	}
+
+	resp, err := f.router.Delegate(body)
 	if err != nil {
-		resp := funky.Message{
-			Context: &funky.Context{
-				Error: &funky.Error{
-					ErrorType: funky.InputError,
-					Message:   fmt.Sprintf("Invalid Input: %s", err),
-				},
-			},
+		resp = funky.NewErrorMessage(funky.SystemError, err)
+	}
+
+	if f.httpContext != nil && resp.Context.Error == nil {
+		if result, ok := funky.ParseHTTPResult(resp.Payload); ok {
+			w.Header().Set(funky.InvocationIDHeader, resp.Context.InvocationID)
+			result.Write(w)
+			return
 		}
-		out, _ := json.Marshal(resp)
-		fmt.Fprintf(w, string(out))
+	}
+
+	f.writeMessage(w, resp)
+}
+
+// invokeFunction serves functionsPath{name}, invoking the named function like requests naming it in FunctionHeader
+func (f funkyHandler) invokeFunction(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, functionsPath)
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
 		return
 	}
 
-	resp, _ := f.router.Delegate(&body)
+	r.Header.Set(funky.FunctionHeader, name)
+	f.ServeHTTP(w, r)
+}
+
+// writeMessage writes resp as JSON, or the payload as-is if the function returned a successful RawPayload
+func (f funkyHandler) writeMessage(w http.ResponseWriter, resp *funky.Message) {
+	if raw, ok := resp.Payload.(*funky.RawPayload); ok && (resp.Context == nil || resp.Context.Error == nil) {
+		w.Header().Set("Content-Type", raw.ContentType)
+		if resp.Context != nil && resp.Context.InvocationID != "" {
+			w.Header().Set(funky.InvocationIDHeader, resp.Context.InvocationID)
+		}
+		w.Write(raw.Data)
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	if f.statusCodes && resp.Context != nil {
+		w.WriteHeader(funky.StatusCode(resp.Context.Error, f.functionErrorStatus))
+	}
 	json.NewEncoder(w).Encode(resp)
 }
 
+// stream writes every chunk of the function's response as a line {"payload": chunk} as it arrives,
+// followed by a line {"context": ...} holding the logs and error of the invocation. An error before the first chunk
+// sets the status code as for other responses; once a chunk was written the status is sent, and an error is only
+// reported in the context line.
+func (f funkyHandler) stream(w http.ResponseWriter, body *funky.Request) {
+	w.Header().Set("Content-Type", funky.NDJSONContentType)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	wrote := false
+	resp, err := f.router.DelegateStream(body, func(chunk json.RawMessage) error {
+		wrote = true
+		if err := encoder.Encode(struct {
+			Payload json.RawMessage `json:"payload"`
+		}{chunk}); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		resp = funky.NewErrorMessage(funky.SystemError, err)
+	}
+
+	if !wrote && f.statusCodes && resp.Context != nil {
+		w.WriteHeader(funky.StatusCode(resp.Context.Error, f.functionErrorStatus))
+	}
+	encoder.Encode(struct {
+		Context *funky.Context `json:"context"`
+	}{resp.Context})
+}
+
+// decode decodes the request body according to its content type, answering with an InputError if it is invalid.
+// The returned function removes the files uploaded with the request.
+func (f funkyHandler) decode(w http.ResponseWriter, r *http.Request) (*funky.Request, func(), bool) {
+	body, cleanup, err := f.ingester.Decode(w, r)
+	if err != nil {
+		f.writeMessage(w, funky.NewErrorMessage(funky.InputError, fmt.Errorf("Invalid Input: %s", err)))
+		return nil, nil, false
+	}
+
+	if f.httpContext != nil {
+		f.httpContext.Apply(body, r)
+	}
+	if cacheControl := r.Header.Get("Cache-Control"); cacheControl != "" {
+		setContextValue(body, funky.CacheControlContextKey, cacheControl)
+	}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		setContextValue(body, funky.IdempotencyKeyContextKey, key)
+	}
+	if function := r.Header.Get(funky.FunctionHeader); function != "" {
+		setContextValue(body, funky.FunctionContextKey, function)
+	}
+	if priority := r.Header.Get(funky.PriorityHeader); priority != "" {
+		setContextValue(body, funky.PriorityContextKey, priority)
+	}
+
+	return body, cleanup, true
+}
+
+func setContextValue(body *funky.Request, key string, value interface{}) {
+	if body.Context == nil {
+		body.Context = map[string]interface{}{}
+	}
+	body.Context[key] = value
+}
+
+// newFunctionRouter returns the router of fn, with its servers on the ports from firstPort, and its circuit
+// breaker if there is one
+func newFunctionRouter(fn functionConfig, c *config, metrics *funky.Metrics, firstPort uint16) (funky.Router, *funky.CircuitBreaker, error) {
+	serverFactory, err := funky.NewDefaultServerFactoryWithOptions(fn.command, c.server)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid server configuration: %+v", err)
+	}
+
+	options := c.router
+	options.Metrics = metrics
+	options.FirstPort = firstPort
+	options.MaxServers = fn.maxServers
+	options.DefaultTimeout = fn.timeout
+	options.OnDemand = fn.onDemand
+	options.IdleTimeout = fn.idleTimeout
+
+	router, err := funky.NewRouterWithOptions(fn.servers, serverFactory, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.breaker.FailureRate <= 0 {
+		return router, nil, nil
+	}
+
+	breakerOptions := c.breaker
+	breakerOptions.Metrics = metrics
+	breaker := funky.NewCircuitBreaker(router, breakerOptions)
+	return breaker, breaker, nil
+}
+
+// reload replaces the servers of router so that they run the current function code
+func reload(router funky.Router, reason string) {
+	log.Printf("Reloading servers after %s", reason)
+	if err := router.Reload(); err != nil {
+		log.Printf("Failed reloading servers: %+v", err)
+		return
+	}
+	log.Printf("Reloaded servers")
+}
+
 func healthy(c <-chan struct{}) bool {
 	select {
 	case <-c:
@@ -59,37 +217,164 @@ func healthy(c <-chan struct{}) bool {
 }
 
 func main() {
-	numServers, err := strconv.Atoi(os.Getenv(serversEnvVar))
-	if err != nil {
-		log.Fatalf("Unable to parse %s environment variable", serversEnvVar)
+	if len(os.Args) > 1 && os.Args[1] == funky.IsolationInitArg {
+		os.Exit(funky.IsolationInit(os.Args[2:]))
 	}
-	if numServers < 1 {
-		numServers = 1
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	// SERVER_CMD is the default function; it may be left out if FUNCTIONS_CONFIG names other functions
+	functions := config.functions
 	serverCmd := os.Getenv(serverCmdEnvVar)
-	serverFactory, err := funky.NewDefaultServerFactory(serverCmd)
-	if err != nil {
-		log.Fatal("Too few arguments to server command.")
+	if serverCmd != "" || len(functions) == 0 {
+		numServers, err := strconv.Atoi(os.Getenv(serversEnvVar))
+		if err != nil {
+			log.Fatalf("Unable to parse %s environment variable", serversEnvVar)
+		}
+		if numServers < 1 {
+			numServers = 1
+		}
+
+		for _, fn := range functions {
+			if fn.name == defaultFunction {
+				log.Fatalf("Function %s in %s conflicts with %s", defaultFunction, functionsEnvVar, serverCmdEnvVar)
+			}
+		}
+		functions = append([]functionConfig{{
+			name:        defaultFunction,
+			command:     serverCmd,
+			servers:     numServers,
+			maxServers:  config.router.MaxServers,
+			onDemand:    config.router.OnDemand,
+			idleTimeout: config.router.IdleTimeout,
+		}}, functions...)
 	}
 
-	router, err := funky.NewRouter(numServers, serverFactory)
-	if err != nil {
-		log.Fatalf("Failed creating new router: %+v", err)
+	metrics := funky.NewMetrics()
+	numServers := 0
+	firstPort := funky.FirstPort
+	routers := map[string]funky.Router{}
+	breakers := map[string]*funky.CircuitBreaker{}
+	for _, fn := range functions {
+		fnMetrics := metrics
+		if len(config.functions) > 0 {
+			fnMetrics = metrics.With("function", fn.name)
+		}
+
+		router, breaker, err := newFunctionRouter(fn, config, fnMetrics, firstPort)
+		if err != nil {
+			log.Fatalf("Failed creating router of function %s: %+v", fn.name, err)
+		}
+		routers[fn.name] = router
+		if breaker != nil {
+			breakers[fn.name] = breaker
+		}
+		numServers += fn.servers
+		// leave room for the pool to grow up to its maximum size
+		if fn.maxServers > fn.servers {
+			firstPort += uint16(fn.maxServers)
+		} else {
+			firstPort += uint16(fn.servers)
+		}
+	}
+
+	var router funky.Router = routers[defaultFunction]
+	if len(config.functions) > 0 {
+		defaultName := ""
+		if router != nil {
+			defaultName = defaultFunction
+		}
+		router = funky.NewRegistry(routers, defaultName)
+	}
+	if config.cache.TTL > 0 {
+		config.cache.Metrics = metrics
+		router = funky.NewCachingRouter(router, config.cache)
+	}
+	if config.coalesce {
+		router = funky.NewCoalescingRouter(router, config.coalesceKeys, metrics)
+	}
+	if config.inputSchema != nil || config.outputSchema != nil {
+		router = funky.NewValidatingRouter(router, config.inputSchema, config.outputSchema)
 	}
 
 	handler := funkyHandler{
-		router: router,
+		router:   router,
+		ingester: funky.NewIngester(config.ingest.spoolDir, config.ingest.maxBytes),
+
+		statusCodes:         config.statusCodes,
+		functionErrorStatus: config.functionErrorStatus,
+	}
+	if config.httpContext {
+		handler.httpContext = funky.NewHTTPContext(config.httpHeaders)
+	}
+
+	store, err := funky.NewInvocationStore(config.async.ttl, config.async.maxEntries, config.async.journal)
+	if err != nil {
+		log.Fatalf("Failed opening invocation store: %+v", err)
+	}
+
+	cb := config.callbacks
+	notifier := funky.NewWebhookNotifier(cb.secret, cb.maxAttempts, cb.backoff, cb.timeout, cb.maxFailed, cb.allowed)
+	invocations := invocationsHandler{
+		funkyHandler: handler,
+		async:        funky.NewAsyncInvoker(router, store, notifier),
 	}
 
 	servMux := http.NewServeMux()
-	servMux.Handle("/", handler)
+	metricsPath := "/metrics"
+	if config.proxy {
+		// leave /metrics to the function servers, which often serve their own
+		metricsPath = "/_funky/metrics"
+
+		proxy := proxyHandler{
+			router: router,
+		}
+		if config.proxyLogs {
+			proxy.logs = store
+		}
+		servMux.Handle("/", proxy)
+	} else {
+		servMux.Handle("/", handler)
+		if len(config.functions) > 0 {
+			servMux.HandleFunc(functionsPath, handler.invokeFunction)
+		}
+		servMux.Handle("/invocations", invocations)
+		servMux.Handle("/invocations/", invocations)
+
+		concurrency := config.batch.concurrency
+		if concurrency == 0 {
+			concurrency = numServers
+		}
+		servMux.Handle("/batch", batchHandler{
+			router:      router,
+			concurrency: concurrency,
+			maxItems:    config.batch.maxItems,
+			maxBytes:    config.batch.maxBytes,
+		})
+	}
+	servMux.Handle(metricsPath, metrics)
 	servMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		if !healthy(funky.Healthy) {
 			w.WriteHeader(500)
 		}
 
-		w.Write([]byte("{}"))
+		// an open circuit is reported, but not as unhealthy, since restarting funky would not help the function
+		status := map[string]interface{}{}
+		if len(config.functions) == 0 {
+			if breaker, ok := breakers[defaultFunction]; ok {
+				status["circuit"] = breaker.State()
+			}
+		} else if len(breakers) > 0 {
+			circuits := map[string]funky.CircuitState{}
+			for name, breaker := range breakers {
+				circuits[name] = breaker.State()
+			}
+			status["circuits"] = circuits
+		}
+		json.NewEncoder(w).Encode(status)
 	})
 
 	port := os.Getenv(portEnvVar)
@@ -102,12 +387,71 @@ func main() {
 		Handler: servMux,
 	}
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload(router, "SIGHUP")
+		}
+	}()
+	stopSecrets := make(chan struct{})
+	if config.secrets.secrets != nil && config.secrets.interval > 0 {
+		go config.secrets.secrets.Watch(config.secrets.interval, stopSecrets, func() {
+			// servers get the secrets in their environment when started, requests get them from the router
+			if config.server.Secrets != nil {
+				reload(router, "changes in "+os.Getenv(secretsPathEnvVar))
+			}
+		})
+	}
+	if config.reload.watchDir != "" {
+		watcher, err := funky.WatchDirectory(config.reload.watchDir, config.reload.debounce, func() {
+			reload(router, "changes in "+config.reload.watchDir)
+		})
+		if err != nil {
+			log.Fatalf("Failed watching %s: %+v", config.reload.watchDir, err)
+		}
+		defer watcher.Close()
+	}
+
+	// the logs of passed through requests are only served by the admin API, as they may hold anything
+	var proxyLogs *funky.InvocationStore
+	if config.proxy && config.proxyLogs {
+		proxyLogs = store
+	}
+
+	var adminServer *http.Server
+	if config.admin.port != "" {
+		adminServer = &http.Server{
+			Addr: ":" + config.admin.port,
+			Handler: adminHandler{
+				router:    router,
+				functions: routers,
+				notifier:  notifier,
+				proxyLogs: proxyLogs,
+				token:     config.admin.token,
+			},
+		}
+		go func() {
+			if err := adminServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatalf("Failed serving the admin API: %+v", err)
+			}
+		}()
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		<-c
+		close(stopSecrets)
 		server.Shutdown(context.TODO())
+		if adminServer != nil {
+			adminServer.Shutdown(context.TODO())
+		}
 		router.Shutdown()
+		store.Close()
+		if config.server.LogSink != nil {
+			config.server.LogSink.Close()
+		}
 		os.Exit(0)
 	}()
 
@@ -0,0 +1,70 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+// AsyncInvoker a struct to run invocations in the background, keeping their results in an InvocationStore
+type AsyncInvoker struct {
+	router   Router
+	store    *InvocationStore
+	notifier *WebhookNotifier
+}
+
+// NewAsyncInvoker returns an AsyncInvoker delegating to router. If notifier is not nil, invocations with a
+// callback URL under CallbackContextKey have their result POSTed to it when they complete.
+func NewAsyncInvoker(router Router, store *InvocationStore, notifier *WebhookNotifier) *AsyncInvoker {
+	return &AsyncInvoker{
+		router:   router,
+		store:    store,
+		notifier: notifier,
+	}
+}
+
+// Submit starts the invocation of input in the background and returns it, pending.
+// The ID is taken from the request context if present, so that it matches the invocation ID in logs.
+// done, if not nil, is called once the invocation completed, e.g. to remove the files uploaded with it.
+func (a *AsyncInvoker) Submit(input *Request, done func()) (*Invocation, error) {
+	id, _ := input.Context[InvocationIDContextKey].(string)
+	if id == "" {
+		id = NewInvocationID()
+		input = withContextValue(input, InvocationIDContextKey, id)
+	}
+
+	callback, _ := input.Context[CallbackContextKey].(string)
+	if callback != "" {
+		if a.notifier == nil {
+			return nil, BadRequestError("callbacks are not enabled")
+		}
+		if err := a.notifier.ValidateCallback(callback); err != nil {
+			return nil, err
+		}
+	}
+
+	inv, err := a.store.Create(id)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		resp, err := a.router.Delegate(input)
+		if err != nil {
+			resp = NewErrorMessage(SystemError, err)
+		}
+		a.store.Complete(id, resp)
+		if done != nil {
+			done()
+		}
+
+		if callback != "" {
+			a.notifier.Notify(callback, id, resp)
+		}
+	}()
+
+	return inv, nil
+}
+
+// Get returns the invocation with the given ID
+func (a *AsyncInvoker) Get(id string) (*Invocation, bool) {
+	return a.store.Get(id)
+}
@@ -0,0 +1,57 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"errors"
+	"sync"
+)
+
+// BatchResult a struct to hold the result of one request of a batch
+type BatchResult struct {
+	Index   int      `json:"index"`
+	Message *Message `json:"message"`
+}
+
+// Batch delegates every input to router with at most concurrency invocations in flight. emit is called with the
+// index and result of each input as it completes, never concurrently. Failures are reported in the item's Message,
+// so one failure does not abort the batch.
+func Batch(router Router, inputs []*Request, concurrency int, emit func(index int, msg *Message)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var emitLock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+
+	for i, input := range inputs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, input *Request) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			var resp *Message
+			if input == nil {
+				resp = NewErrorMessage(InputError, errors.New("Invalid Input: batch item is null"))
+			} else {
+				var err error
+				resp, err = router.Delegate(input)
+				if err != nil {
+					resp = NewErrorMessage(SystemError, err)
+				}
+			}
+
+			emitLock.Lock()
+			defer emitLock.Unlock()
+			emit(i, resp)
+		}(i, input)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,233 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState the state of a CircuitBreaker
+type CircuitState string
+
+// The states of a CircuitBreaker
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// BreakerOptions settings of a CircuitBreaker
+type BreakerOptions struct {
+	// FailureRate the share of failed invocations, between 0 and 1, at which the circuit opens
+	FailureRate float64
+	// Window the number of most recent invocations the failure rate is computed over
+	Window int
+	// MinRequests the number of invocations in the window below which the circuit stays closed
+	MinRequests int
+	// OpenDuration how long invocations are rejected before probing whether the function recovered
+	OpenDuration time.Duration
+	// Probes the number of invocations let through while half-open, all of which must succeed to close the circuit
+	Probes int
+	// Metrics receives the state of the circuit and the rejected invocations when set
+	Metrics *Metrics
+}
+
+// CircuitBreaker a Router failing fast with a CircuitOpenError while the function keeps failing. Invocations that
+// result in a FunctionError, which includes timeouts, count as failed. Errors of funky itself, such as a full queue,
+// do not count either way.
+type CircuitBreaker struct {
+	Router
+	options BreakerOptions
+
+	lock     sync.Mutex
+	state    CircuitState
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+	openedAt time.Time
+	probes   int
+	passed   int
+
+	rejected, opened *Counter
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker delegating to router
+func NewCircuitBreaker(router Router, options BreakerOptions) *CircuitBreaker {
+	if options.Window < 1 {
+		options.Window = 1
+	}
+	if options.Probes < 1 {
+		options.Probes = 1
+	}
+
+	b := &CircuitBreaker{
+		Router:   router,
+		options:  options,
+		state:    CircuitClosed,
+		outcomes: make([]bool, options.Window),
+
+		rejected: options.Metrics.Counter("funky_circuit_rejected_total", "Invocations rejected while the circuit was open."),
+		opened:   options.Metrics.Counter("funky_circuit_opened_total", "Times the circuit opened."),
+	}
+
+	for _, state := range []CircuitState{CircuitClosed, CircuitOpen, CircuitHalfOpen} {
+		state := state
+		options.Metrics.Gauge("funky_circuit_state", "Whether the circuit is in the given state.", func() float64 {
+			if b.State() == state {
+				return 1
+			}
+			return 0
+		}, "state", string(state))
+	}
+
+	return b
+}
+
+// State returns the current state of the circuit
+func (b *CircuitBreaker) State() CircuitState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.options.OpenDuration {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// Delegate delegates input unless the circuit is open
+func (b *CircuitBreaker) Delegate(input *Request) (*Message, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.Router.Delegate(input)
+	b.record(resp, err, probe)
+	return resp, err
+}
+
+// DelegateStream delegates input unless the circuit is open
+func (b *CircuitBreaker) DelegateStream(input *Request, chunk func(json.RawMessage) error) (*Message, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.Router.DelegateStream(input, chunk)
+	b.record(resp, err, probe)
+	return resp, err
+}
+
+// Forward passes the request through unless the circuit is open
+func (b *CircuitBreaker) Forward(w http.ResponseWriter, r *http.Request) (*Message, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.Router.Forward(w, r)
+	b.record(resp, err, probe)
+	return resp, err
+}
+
+// allow returns whether an invocation may proceed, and if so, whether it probes a half-open circuit
+func (b *CircuitBreaker) allow() (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == CircuitOpen {
+		retryIn := b.options.OpenDuration - time.Since(b.openedAt)
+		if retryIn > 0 {
+			b.rejected.Inc()
+			return false, CircuitOpenError(fmt.Sprintf("probing again in %s", retryIn.Round(time.Second)))
+		}
+		b.state = CircuitHalfOpen
+		b.probes = 0
+		b.passed = 0
+	}
+
+	if b.state == CircuitHalfOpen {
+		if b.probes >= b.options.Probes {
+			b.rejected.Inc()
+			return false, CircuitOpenError("waiting for probe invocations")
+		}
+		b.probes++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// record accounts for the result of an invocation allowed by allow
+func (b *CircuitBreaker) record(resp *Message, err error, probe bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	failed := err == nil && resp != nil && resp.Context != nil && resp.Context.Error != nil &&
+		resp.Context.Error.ErrorType == FunctionError
+
+	if probe {
+		if b.state != CircuitHalfOpen {
+			// another probe already decided
+			return
+		}
+		switch {
+		case err != nil:
+			b.probes--
+		case failed:
+			b.open()
+		default:
+			b.passed++
+			if b.passed >= b.options.Probes {
+				b.close()
+			}
+		}
+		return
+	}
+
+	if b.state != CircuitClosed || err != nil {
+		return
+	}
+
+	if b.count == len(b.outcomes) {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.count++
+	}
+	b.outcomes[b.next] = failed
+	b.next = (b.next + 1) % len(b.outcomes)
+	if failed {
+		b.failures++
+	}
+
+	if b.count >= b.options.MinRequests && float64(b.failures) >= b.options.FailureRate*float64(b.count) {
+		b.open()
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = CircuitOpen
+	b.openedAt = time.Now()
+	b.opened.Inc()
+	b.reset()
+}
+
+func (b *CircuitBreaker) close() {
+	b.state = CircuitClosed
+	b.reset()
+}
+
+func (b *CircuitBreaker) reset() {
+	b.next = 0
+	b.count = 0
+	b.failures = 0
+}
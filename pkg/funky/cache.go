@@ -0,0 +1,211 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheControlContextKey the Request.Context key holding the caller's Cache-Control directives
+const CacheControlContextKey = "cacheControl"
+
+// CacheOptions settings of a CachingRouter
+type CacheOptions struct {
+	// TTL how long results are served from the cache
+	TTL time.Duration
+	// MaxBytes bounds the approximate size of the cached results, if positive
+	MaxBytes int64
+	// ContextKeys the Request.Context fields that, besides the payload, make up the cache key
+	ContextKeys []string
+	// Metrics receives the hits, misses and evictions of the cache when set
+	Metrics *Metrics
+}
+
+// CachingRouter a Router serving repeated invocations with the same payload from a cache of successful results
+type CachingRouter struct {
+	Router
+	options CacheOptions
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+
+	hits, misses, evictions *Counter
+}
+
+type cacheEntry struct {
+	key     string
+	payload interface{}
+	size    int64
+	expires time.Time
+}
+
+// NewCachingRouter returns a CachingRouter delegating to router
+func NewCachingRouter(router Router, options CacheOptions) *CachingRouter {
+	c := &CachingRouter{
+		Router:  router,
+		options: options,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+
+		hits:      options.Metrics.Counter("funky_cache_hits_total", "Invocations served from the result cache."),
+		misses:    options.Metrics.Counter("funky_cache_misses_total", "Invocations not found in the result cache."),
+		evictions: options.Metrics.Counter("funky_cache_evictions_total", "Results evicted from the cache to stay within its size."),
+	}
+
+	options.Metrics.Gauge("funky_cache_entries", "Results in the cache.", func() float64 {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return float64(c.lru.Len())
+	})
+	options.Metrics.Gauge("funky_cache_bytes", "Approximate size of the results in the cache.", func() float64 {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return float64(c.size)
+	})
+
+	return c
+}
+
+// Delegate returns the cached result for input if there is one, and otherwise delegates and caches the result
+// unless it failed. The Cache-Control directives no-cache, which skips the lookup, and no-store, which also skips
+// caching the result, are honoured.
+func (c *CachingRouter) Delegate(input *Request) (*Message, error) {
+	key, ok := requestHash(input, c.options.ContextKeys)
+	if !ok {
+		return c.Router.Delegate(input)
+	}
+
+	noCache, noStore := cacheDirectives(input)
+	if !noCache && !noStore {
+		id, _ := input.Context[InvocationIDContextKey].(string)
+		if resp := c.get(key, id); resp != nil {
+			c.hits.Inc()
+			return resp, nil
+		}
+	}
+	c.misses.Inc()
+
+	resp, err := c.Router.Delegate(input)
+	if err == nil && !noStore && resp.Context != nil && resp.Context.Error == nil {
+		c.put(key, resp)
+	}
+	return resp, err
+}
+
+// Reload empties the cache, as the reloaded function may return different results, and reloads the router
+func (c *CachingRouter) Reload() error {
+	c.lock.Lock()
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.size = 0
+	c.lock.Unlock()
+
+	return c.Router.Reload()
+}
+
+// requestHash returns the hash of the payload, function and given context fields of input, false if they cannot be
+// serialized
+func requestHash(input *Request, contextKeys []string) (string, bool) {
+	fields := map[string]interface{}{}
+	if function, ok := input.Context[FunctionContextKey]; ok {
+		fields[FunctionContextKey] = function
+	}
+	for _, k := range contextKeys {
+		fields[k] = input.Context[k]
+	}
+
+	// maps are serialized with sorted keys, which makes the encoding canonical
+	b, err := json.Marshal([]interface{}{input.Payload, fields})
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// get returns the cached result for key as the result of the invocation id. Only successful results are cached, so
+// the payload is all a hit takes from the invocation that produced it, not its logs or ID.
+func (c *CachingRouter) get(key string, id string) *Message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+
+	return &Message{
+		Context: &Context{
+			Logs:         &Logs{},
+			InvocationID: id,
+			Cached:       true,
+		},
+		Payload: entry.payload,
+	}
+}
+
+func (c *CachingRouter) put(key string, resp *Message) {
+	b, err := json.Marshal(resp.Payload)
+	if err != nil {
+		return
+	}
+	size := int64(len(b) + len(key))
+	if c.options.MaxBytes > 0 && size > c.options.MaxBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		payload: resp.Payload,
+		size:    size,
+		expires: time.Now().Add(c.options.TTL),
+	})
+	c.size += size
+
+	for c.options.MaxBytes > 0 && c.size > c.options.MaxBytes {
+		c.remove(c.lru.Back())
+		c.evictions.Inc()
+	}
+}
+
+func (c *CachingRouter) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// cacheDirectives returns whether the request's Cache-Control directives include no-cache and no-store
+func cacheDirectives(input *Request) (noCache bool, noStore bool) {
+	directives, _ := input.Context[CacheControlContextKey].(string)
+	for _, d := range strings.Split(directives, ",") {
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "no-cache":
+			noCache = true
+		case "no-store":
+			noStore = true
+		}
+	}
+	return
+}
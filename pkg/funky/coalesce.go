@@ -0,0 +1,134 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyContextKey the Request.Context key holding the caller's idempotency key
+const IdempotencyKeyContextKey = "idempotencyKey"
+
+// CoalescingRouter a Router letting concurrent identical invocations share a single delegated invocation.
+// Invocations are identical if they invoke the same function with the same idempotency key or, lacking one, the
+// same payload and values of the configured context fields. An invocation reusing the idempotency key of one in flight
+// with another payload is rejected.
+type CoalescingRouter struct {
+	Router
+	contextKeys []string
+	coalesced   *Counter
+
+	lock     sync.Mutex
+	inFlight map[string]*flight
+	// waiting the invocations waiting for the result of another
+	waiting int
+}
+
+type flight struct {
+	// hash the hash of the payload and context fields of the delegated invocation
+	hash string
+	done chan struct{}
+	resp *Message
+	err  error
+}
+
+// NewCoalescingRouter returns a CoalescingRouter delegating to router. metrics may be nil.
+func NewCoalescingRouter(router Router, contextKeys []string, metrics *Metrics) *CoalescingRouter {
+	c := &CoalescingRouter{
+		Router:      router,
+		contextKeys: contextKeys,
+		coalesced:   metrics.Counter("funky_coalesced_total", "Invocations that shared the result of an identical invocation in flight."),
+		inFlight:    map[string]*flight{},
+	}
+
+	metrics.Gauge("funky_coalesced_waiting", "Invocations waiting for the result of an identical invocation in flight.", func() float64 {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return float64(c.waiting)
+	})
+
+	return c
+}
+
+// Delegate delegates input unless an identical invocation is in flight, in which case it waits for and returns
+// that invocation's result, with Context.Coalesced set, or fails once the deadline of input passed
+func (c *CoalescingRouter) Delegate(input *Request) (*Message, error) {
+	hash, hashed := requestHash(input, c.contextKeys)
+	key, _ := input.Context[IdempotencyKeyContextKey].(string)
+	if key != "" {
+		// idempotency keys are chosen by callers, who may use the same key for different functions
+		function, _ := input.Context[FunctionContextKey].(string)
+		key = fmt.Sprintf("key:%q:%s", function, key)
+	} else if hashed {
+		key = "hash:" + hash
+	} else {
+		return c.Router.Delegate(input)
+	}
+
+	c.lock.Lock()
+	if f, ok := c.inFlight[key]; ok {
+		if f.hash != hash {
+			c.lock.Unlock()
+			return NewErrorMessage(InputError, BadRequestError(fmt.Sprintf(
+				"Invalid Input: idempotency key %q is in use by an invocation with another payload",
+				input.Context[IdempotencyKeyContextKey]))), nil
+		}
+		c.waiting++
+		c.lock.Unlock()
+
+		defer func() {
+			c.lock.Lock()
+			c.waiting--
+			c.lock.Unlock()
+		}()
+		return c.wait(f, input)
+	}
+	f := &flight{hash: hash, done: make(chan struct{})}
+	c.inFlight[key] = f
+	c.lock.Unlock()
+
+	f.resp, f.err = c.Router.Delegate(input)
+
+	c.lock.Lock()
+	delete(c.inFlight, key)
+	c.lock.Unlock()
+	close(f.done)
+
+	return f.result(false)
+}
+
+// wait returns the result of f once it completes, or fails if the deadline of input passes first
+func (c *CoalescingRouter) wait(f *flight, input *Request) (*Message, error) {
+	var timeout <-chan time.Time
+	if deadline, ok := invocationDeadline(input); ok {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-f.done:
+		c.coalesced.Inc()
+		return f.result(true)
+	case <-timeout:
+		return nil, TimeoutError("Deadline exceeded waiting for an identical invocation")
+	}
+}
+
+// result returns a copy of the flight's result, as routers wrapping this one may modify the context
+func (f *flight) result(coalesced bool) (*Message, error) {
+	if f.resp == nil || f.resp.Context == nil {
+		return f.resp, f.err
+	}
+
+	ctx := *f.resp.Context
+	ctx.Coalesced = coalesced
+	return &Message{
+		Context: &ctx,
+		Payload: f.resp.Payload,
+	}, f.err
+}
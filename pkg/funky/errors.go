@@ -65,3 +65,34 @@ type UnknownSystemError string
 func (e UnknownSystemError) Error() string {
 	return fmt.Sprintf("Unknown system error: %s", string(e))
 }
+
+// QueueFullError error for when no more invocations can be accepted
+type QueueFullError string
+
+func (e QueueFullError) Error() string {
+	return fmt.Sprintf("Too many pending invocations: %s", string(e))
+}
+
+// CircuitOpenError error for invocations rejected because the function keeps failing
+type CircuitOpenError string
+
+func (e CircuitOpenError) Error() string {
+	return fmt.Sprintf("The function is failing, invocations are rejected: %s", string(e))
+}
+
+// UnknownFunctionError error for invocations of a function that is not registered
+type UnknownFunctionError string
+
+func (e UnknownFunctionError) Error() string {
+	if e == "" {
+		return "No function was named and there is no default function"
+	}
+	return fmt.Sprintf("Unknown function: %s", string(e))
+}
+
+// UnknownServerError error for a port that no server of a Router listens on
+type UnknownServerError uint16
+
+func (e UnknownServerError) Error() string {
+	return fmt.Sprintf("There is no server on port %d", uint16(e))
+}
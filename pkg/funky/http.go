@@ -0,0 +1,173 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPContextKey the Request.Context key holding the HTTPRequestInfo of the triggering request
+const HTTPContextKey = "http"
+
+// HTTPRequestInfo a struct describing the HTTP request that triggered an invocation
+type HTTPRequestInfo struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   url.Values        `json:"query"`
+	Headers map[string]string `json:"headers"`
+}
+
+// HTTPContext a struct to add the method, path, query and allowed headers of HTTP requests to Requests
+type HTTPContext struct {
+	headers []string
+}
+
+// NewHTTPContext returns an HTTPContext passing on the headers matching allowedHeaders.
+// Patterns are header names, or prefixes when ending in '*', and are matched case-insensitively.
+func NewHTTPContext(allowedHeaders []string) *HTTPContext {
+	headers := make([]string, len(allowedHeaders))
+	for i, h := range allowedHeaders {
+		headers[i] = strings.ToLower(h)
+	}
+
+	return &HTTPContext{
+		headers: headers,
+	}
+}
+
+// Apply adds the HTTPRequestInfo of r to the context of input, replacing any the caller sent
+func (c *HTTPContext) Apply(input *Request, r *http.Request) {
+	info := &HTTPRequestInfo{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.Query(),
+		Headers: map[string]string{},
+	}
+	for name, values := range r.Header {
+		if matchesAny(strings.ToLower(name), c.headers) {
+			info.Headers[name] = strings.Join(values, ", ")
+		}
+	}
+
+	if input.Context == nil {
+		input.Context = map[string]interface{}{}
+	}
+	input.Context[HTTPContextKey] = info
+}
+
+// hopByHopHeaders headers describing the connection rather than the response, which a result may not set
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Content-Length":      true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// HTTPResult a function result describing the HTTP response to send to the caller
+type HTTPResult struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Body            interface{}       `json:"body,omitempty"`
+	IsBase64Encoded bool              `json:"isBase64Encoded,omitempty"`
+}
+
+// ParseHTTPResult returns the HTTPResult payload describes. A payload is only taken for an HTTPResult if it is
+// an object with a statusCode from 200 to 599, no other fields than those of HTTPResult and, if IsBase64Encoded is set,
+// a base64 encoded string body.
+func ParseHTTPResult(payload interface{}) (*HTTPResult, bool) {
+	m, ok := payload.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	code, ok := m["statusCode"].(float64)
+	if !ok || code != float64(int(code)) || code < 200 || code > 599 {
+		return nil, false
+	}
+
+	result := &HTTPResult{
+		StatusCode: int(code),
+		Body:       m["body"],
+	}
+	for k, v := range m {
+		switch k {
+		case "statusCode", "body":
+		case "headers":
+			headers, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			result.Headers = map[string]string{}
+			for name, value := range headers {
+				s, ok := value.(string)
+				if !ok {
+					return nil, false
+				}
+				result.Headers[name] = s
+			}
+		case "isBase64Encoded":
+			if result.IsBase64Encoded, ok = v.(bool); !ok {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+
+	if result.IsBase64Encoded {
+		body, ok := result.Body.(string)
+		if !ok {
+			return nil, false
+		}
+		if _, err := base64.StdEncoding.DecodeString(body); err != nil {
+			return nil, false
+		}
+	}
+
+	return result, true
+}
+
+// Write writes the result to w. A string body is written as-is, after decoding it if IsBase64Encoded is set,
+// any other body is written as JSON. Hop-by-hop headers are left out, they are up to the server.
+func (h *HTTPResult) Write(w http.ResponseWriter) error {
+	var body []byte
+	switch b := h.Body.(type) {
+	case nil:
+	case string:
+		body = []byte(b)
+		if h.IsBase64Encoded {
+			decoded, err := base64.StdEncoding.DecodeString(b)
+			if err != nil {
+				return InvalidResponsePayloadError(err.Error())
+			}
+			body = decoded
+		}
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			return InvalidResponsePayloadError(err.Error())
+		}
+		body = encoded
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	for name, value := range h.Headers {
+		if !hopByHopHeaders[http.CanonicalHeaderKey(name)] {
+			w.Header().Set(name, value)
+		}
+	}
+	w.WriteHeader(h.StatusCode)
+	_, err := w.Write(body)
+	return err
+}
@@ -0,0 +1,226 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Request.Context keys describing payloads that were not a JSON Request
+const (
+	ContentTypeContextKey = "contentType"
+	EncodingContextKey    = "encoding"
+)
+
+// Base64Encoding the encoding of raw payloads
+const Base64Encoding = "base64"
+
+// RawPayload a non-JSON payload returned by a function, sent back to callers as-is
+type RawPayload struct {
+	ContentType string `json:"contentType"`
+	Encoding    string `json:"encoding"`
+	Data        []byte `json:"data"`
+}
+
+// UploadedFile a struct describing a file of a multipart request, spooled to disk and passed to the function by path
+type UploadedFile struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+}
+
+// FormPayload the payload of form and multipart requests
+type FormPayload struct {
+	Fields url.Values                 `json:"fields"`
+	Files  map[string][]*UploadedFile `json:"files,omitempty"`
+}
+
+// Ingester a struct to turn HTTP requests of any content type into Requests
+type Ingester struct {
+	spoolDir string
+	maxBytes int64
+}
+
+// NewIngester returns an Ingester spooling uploaded files to spoolDir, the system's temporary directory if empty,
+// and rejecting bodies larger than maxBytes, if positive
+func NewIngester(spoolDir string, maxBytes int64) *Ingester {
+	if spoolDir == "" {
+		spoolDir = os.TempDir()
+	}
+
+	return &Ingester{
+		spoolDir: spoolDir,
+		maxBytes: maxBytes,
+	}
+}
+
+// Decode returns the Request for r. JSON bodies are decoded as a Request, form bodies become a FormPayload
+// and any other body a base64 encoded payload, with its content type recorded in the context.
+// The returned cleanup function removes spooled files and must be called once the invocation completed.
+func (i *Ingester) Decode(w http.ResponseWriter, r *http.Request) (*Request, func(), error) {
+	noop := func() {}
+	body := r.Body
+	if i.maxBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, i.maxBytes)
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" || (err == nil && isJSONMediaType(mediaType)) {
+		var req Request
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			return nil, noop, BadRequestError(err.Error())
+		}
+		return &req, noop, nil
+	}
+	if err != nil {
+		return nil, noop, BadRequestError(fmt.Sprintf("invalid content type %q", contentType))
+	}
+
+	ctx := map[string]interface{}{
+		ContentTypeContextKey: contentType,
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, noop, BadRequestError(err.Error())
+		}
+		// clients such as curl send JSON bodies as forms by default
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			var req Request
+			if err := json.Unmarshal(trimmed, &req); err != nil {
+				return nil, noop, BadRequestError(err.Error())
+			}
+			return &req, noop, nil
+		}
+		fields, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, noop, BadRequestError(err.Error())
+		}
+		return &Request{Context: ctx, Payload: &FormPayload{Fields: fields}}, noop, nil
+	case "multipart/form-data":
+		payload, cleanup, err := i.spool(body, params["boundary"])
+		if err != nil {
+			return nil, noop, err
+		}
+		return &Request{Context: ctx, Payload: payload}, cleanup, nil
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, noop, BadRequestError(err.Error())
+	}
+	ctx[EncodingContextKey] = Base64Encoding
+	return &Request{Context: ctx, Payload: base64.StdEncoding.EncodeToString(data)}, noop, nil
+}
+
+// spool writes the files of a multipart body to a new directory under the spool directory
+func (i *Ingester) spool(body io.Reader, boundary string) (*FormPayload, func(), error) {
+	noop := func() {}
+	if boundary == "" {
+		return nil, noop, BadRequestError("multipart body without boundary")
+	}
+
+	dir, err := ioutil.TempDir(i.spoolDir, "funky-upload-")
+	if err != nil {
+		return nil, noop, UnknownSystemError(err.Error())
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	payload := &FormPayload{
+		Fields: url.Values{},
+		Files:  map[string][]*UploadedFile{},
+	}
+
+	mr := multipart.NewReader(body, boundary)
+	for n := 0; ; n++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cleanup()
+			return nil, noop, BadRequestError(err.Error())
+		}
+
+		name := part.FormName()
+		if part.FileName() == "" {
+			value, err := ioutil.ReadAll(part)
+			if err != nil {
+				cleanup()
+				return nil, noop, BadRequestError(err.Error())
+			}
+			payload.Fields[name] = append(payload.Fields[name], string(value))
+			continue
+		}
+
+		// the client's file name is not trusted as a path
+		path := filepath.Join(dir, fmt.Sprintf("%d-%s", n, filepath.Base(part.FileName())))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+		if err != nil {
+			cleanup()
+			return nil, noop, UnknownSystemError(err.Error())
+		}
+		size, err := io.Copy(f, part)
+		f.Close()
+		if err != nil {
+			cleanup()
+			return nil, noop, BadRequestError(err.Error())
+		}
+
+		payload.Files[name] = append(payload.Files[name], &UploadedFile{
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Path:        path,
+			Size:        size,
+		})
+	}
+
+	return payload, cleanup, nil
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// decodeResult decodes the body of a function server's response. JSON, and JSON sent as text/plain or without
+// a content type, is decoded; anything else is returned as a RawPayload.
+func decodeResult(contentType string, body []byte) (interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if isJSONMediaType(mediaType) || ((mediaType == "" || mediaType == "text/plain") && json.Valid(body)) {
+		var result interface{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, InvalidResponsePayloadError(err.Error())
+		}
+		return result, nil
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &RawPayload{
+		ContentType: contentType,
+		Encoding:    Base64Encoding,
+		Data:        body,
+	}, nil
+}
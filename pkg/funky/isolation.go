@@ -0,0 +1,61 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// IsolationInitArg the first argument funky is re-executed with to set up an isolated server
+const IsolationInitArg = "funky-isolation-init"
+
+// DefaultSocketDir the directory holding the Unix sockets of network isolated servers
+const DefaultSocketDir = "/var/run/funky"
+
+// IsolationConfig a struct to configure the Linux namespaces a server is started in.
+// Isolated servers always get new PID, mount, IPC and UTS namespaces and a private /tmp.
+type IsolationConfig struct {
+	// Network gives each server its own network namespace, reachable only through a Unix socket
+	Network bool
+	// SocketDir the directory the Unix sockets are created in, must not be inside /tmp
+	SocketDir string
+}
+
+func (c *IsolationConfig) validate() error {
+	if !c.Network {
+		return nil
+	}
+
+	if c.SocketDir == "" {
+		c.SocketDir = DefaultSocketDir
+	}
+
+	dir, err := filepath.Abs(c.SocketDir)
+	if err != nil {
+		return IllegalArgumentError("SocketDir")
+	}
+
+	// the server's private /tmp would hide its socket from funky
+	if dir == "/tmp" || strings.HasPrefix(dir, "/tmp/") {
+		return IllegalArgumentError("SocketDir")
+	}
+
+	c.SocketDir = dir
+	return nil
+}
+
+// unixTransport returns a transport that reaches a server through the Unix socket at path, whatever the request URL
+func unixTransport(path string) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+}
@@ -0,0 +1,202 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+
+//go:build linux
+// +build linux
+
+package funky
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// isolateCommand wraps the server command so that funky re-executes itself inside new namespaces,
+// sets them up and then starts the server. Returns the Unix socket the server is reachable on, if any.
+func isolateCommand(port uint16, name string, args []string, config *IsolationConfig) (*exec.Cmd, string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, "", err
+	}
+
+	socket := ""
+	flags := syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if config.Network {
+		if err := os.MkdirAll(config.SocketDir, 0700); err != nil {
+			return nil, "", err
+		}
+		socket = filepath.Join(config.SocketDir, fmt.Sprintf("funky-%d.sock", port))
+		flags |= syscall.CLONE_NEWNET
+	}
+
+	cmd := exec.Command(self, append([]string{IsolationInitArg, socket, name}, args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: uintptr(flags),
+		Pdeathsig:  syscall.SIGKILL,
+	}
+
+	return cmd, socket, nil
+}
+
+// IsolationInit runs as PID 1 of an isolated server: it makes /tmp and /proc private, bridges the
+// server port to a Unix socket when in its own network namespace, runs the server command and reaps
+// the processes the server forks once they are orphaned.
+// args are the Unix socket path (possibly empty) followed by the server command. Returns the exit code.
+func IsolationInit(args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "isolation init: missing server command")
+		return 1
+	}
+	socket, name, cmdArgs := args[0], args[1], args[2:]
+
+	if err := setupMounts(); err != nil {
+		fmt.Fprintf(os.Stderr, "isolation init: %s\n", err)
+		return 1
+	}
+
+	syscall.Sethostname([]byte(fmt.Sprintf("funky-%s", os.Getenv("PORT"))))
+
+	if socket != "" {
+		if err := loopbackUp(); err != nil {
+			fmt.Fprintf(os.Stderr, "isolation init: unable to bring up loopback: %s\n", err)
+			return 1
+		}
+		l, err := listenUnix(socket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "isolation init: %s\n", err)
+			return 1
+		}
+		defer l.Close()
+		go bridge(l, fmt.Sprintf("127.0.0.1:%s", os.Getenv("PORT")))
+	}
+
+	// registered before the server starts, so that its exit cannot go unnoticed
+	children := make(chan os.Signal, 1)
+	signal.Notify(children, syscall.SIGCHLD)
+
+	cmd := exec.Command(name, cmdArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "isolation init: %s\n", err)
+		return 1
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	status := reap(children, cmd.Process.Pid)
+	if status.Exited() {
+		return status.ExitStatus()
+	}
+	return 1
+}
+
+// reap waits for the children of PID 1 as they exit, the orphans the server leaves behind included, until the
+// server with pid exited. Returns the exit status of the server.
+func reap(children chan os.Signal, pid int) syscall.WaitStatus {
+	for range children {
+		for {
+			var status syscall.WaitStatus
+			child, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || child <= 0 {
+				break
+			}
+			if child == pid {
+				return status
+			}
+		}
+	}
+	return 0
+}
+
+func setupMounts() error {
+	// keep our mounts from propagating back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("unable to make mounts private: %s", err)
+	}
+	if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("unable to mount private /tmp: %s", err)
+	}
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("unable to mount /proc: %s", err)
+	}
+	return nil
+}
+
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq with the ifr_flags member of its union
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:], "lo")
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	ifr.flags |= syscall.IFF_UP | syscall.IFF_RUNNING
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func listenUnix(socket string) (net.Listener, error) {
+	// a previous server on this port may have left its socket behind
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// bridge forwards every connection accepted on l to the server listening on addr
+func bridge(l net.Listener, addr string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				return
+			}
+			defer upstream.Close()
+			go io.Copy(upstream, conn)
+			io.Copy(conn, upstream)
+		}()
+	}
+}
@@ -0,0 +1,25 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+
+//go:build !linux
+// +build !linux
+
+package funky
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func isolateCommand(port uint16, name string, args []string, config *IsolationConfig) (*exec.Cmd, string, error) {
+	return nil, "", IllegalArgumentError("server isolation is only supported on Linux")
+}
+
+// IsolationInit is only supported on Linux
+func IsolationInit(args []string) int {
+	fmt.Fprintln(os.Stderr, "server isolation is only supported on Linux")
+	return 1
+}
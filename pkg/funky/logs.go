@@ -0,0 +1,196 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// LogLimits a struct to bound the logs captured per stream for a single invocation. Zero means no limit.
+type LogLimits struct {
+	// MaxLineLength the number of bytes kept of a single line
+	MaxLineLength int
+	// MaxLines the number of lines kept
+	MaxLines int
+	// MaxBytes the number of bytes kept
+	MaxBytes int
+}
+
+// capturedLine a line of output with the time it was read
+type capturedLine struct {
+	time time.Time
+	text string
+	// size the bytes of the line counted against the limits, which leave out a truncation marker
+	size int
+}
+
+// logBuffer keeps the head and the tail of a stream within LogLimits, counting what was dropped in between
+type logBuffer struct {
+	headLines int
+	headBytes int
+	tailLines int
+	tailBytes int
+
+	head      []capturedLine
+	headSize  int
+	tail      []capturedLine
+	tailSize  int
+	dropped   int
+	shortened int
+	// droppedAt the time of the last dropped line
+	droppedAt time.Time
+}
+
+func newLogBuffer(limits LogLimits) *logBuffer {
+	b := &logBuffer{
+		headLines: -1,
+		headBytes: -1,
+		tailLines: -1,
+		tailBytes: -1,
+	}
+
+	// the head gets the larger half of each limit, the tail what is left
+	if limits.MaxLines > 0 {
+		b.headLines = (limits.MaxLines + 1) / 2
+		b.tailLines = limits.MaxLines - b.headLines
+	}
+	if limits.MaxBytes > 0 {
+		b.headBytes = (limits.MaxBytes + 1) / 2
+		b.tailBytes = limits.MaxBytes - b.headBytes
+	}
+
+	return b
+}
+
+func (b *logBuffer) append(line string, shortened bool) {
+	if shortened {
+		b.shortened++
+	}
+
+	captured := capturedLine{time: time.Now().UTC(), text: line, size: len(line)}
+	if b.tail == nil && within(len(b.head)+1, b.headLines) && within(b.headSize+len(line), b.headBytes) {
+		b.head = append(b.head, captured)
+		b.headSize += len(line)
+		return
+	}
+
+	// a line longer than the tail may hold is shortened rather than dropped
+	if b.tailBytes >= 0 && len(line) > b.tailBytes {
+		captured.text = fmt.Sprintf("%s ... [%d bytes truncated]", line[:b.tailBytes], len(line)-b.tailBytes)
+		captured.size = b.tailBytes
+		if !shortened {
+			b.shortened++
+		}
+	}
+
+	b.tail = append(b.tail, captured)
+	b.tailSize += captured.size
+	for len(b.tail) > 0 && (!within(len(b.tail), b.tailLines) || !within(b.tailSize, b.tailBytes)) {
+		b.tailSize -= b.tail[0].size
+		b.droppedAt = b.tail[0].time
+		b.tail = b.tail[1:]
+		b.dropped++
+	}
+}
+
+// captured returns the kept lines with a marker where lines were dropped
+func (b *logBuffer) captured() []capturedLine {
+	if b.head == nil && b.tail == nil && b.dropped == 0 {
+		return nil
+	}
+
+	lines := make([]capturedLine, 0, len(b.head)+len(b.tail)+1)
+	lines = append(lines, b.head...)
+	if b.dropped > 0 {
+		lines = append(lines, capturedLine{
+			time: b.droppedAt,
+			text: fmt.Sprintf("... %d lines truncated ...", b.dropped),
+		})
+	}
+	return append(lines, b.tail...)
+}
+
+// lines returns the text of the kept lines with a marker where lines were dropped
+func (b *logBuffer) lines() []string {
+	captured := b.captured()
+	if captured == nil {
+		return nil
+	}
+
+	lines := make([]string, len(captured))
+	for i, line := range captured {
+		lines[i] = line.text
+	}
+	return lines
+}
+
+// truncated returns the number of lines dropped or shortened
+func (b *logBuffer) truncated() int {
+	return b.dropped + b.shortened
+}
+
+func within(n, limit int) bool {
+	return limit < 0 || n <= limit
+}
+
+// readLines calls fn for every line read from r until EOF. Lines longer than maxLength, if positive,
+// are cut with a marker and the remainder discarded; fn is told whether the line was shortened.
+func readLines(r io.Reader, maxLength int, fn func(line string, shortened bool)) {
+	br := bufio.NewReader(r)
+	for {
+		var line []byte
+		discarded := 0
+		var err error
+		for {
+			var chunk []byte
+			chunk, err = br.ReadSlice('\n')
+			if maxLength > 0 && len(line)+len(chunk) > maxLength {
+				keep := maxLength - len(line)
+				if keep < 0 {
+					keep = 0
+				}
+				discarded += len(chunk) - keep
+				chunk = chunk[:keep]
+			}
+			line = append(line, chunk...)
+			if err != bufio.ErrBufferFull {
+				break
+			}
+		}
+
+		if len(line) == 0 && discarded == 0 && err != nil {
+			return
+		}
+
+		// the newline itself does not count as discarded content
+		if discarded > 0 && err == nil {
+			discarded--
+		}
+		line = trimNewline(line)
+
+		if discarded > 0 {
+			fn(fmt.Sprintf("%s ... [%d bytes truncated]", line, discarded), true)
+		} else {
+			fn(string(line), false)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func trimNewline(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
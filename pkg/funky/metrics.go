@@ -0,0 +1,198 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MetricsContentType the content type of the Prometheus text exposition format
+const MetricsContentType = "text/plain; version=0.0.4"
+
+// Metrics a registry of counters and gauges, exposed in the Prometheus text format.
+// A nil *Metrics is valid and discards everything registered with it.
+type Metrics struct {
+	lock     sync.Mutex
+	families map[string]*metricFamily
+
+	// root the registry a Metrics returned by With registers with, adding labels
+	root   *Metrics
+	labels []string
+}
+
+type metricFamily struct {
+	name, help, kind string
+	counters         map[string]*Counter
+	gauges           map[string]func() float64
+}
+
+// Counter a monotonically increasing value. A nil *Counter is valid and ignores increments.
+type Counter struct {
+	bits uint64
+}
+
+// NewMetrics returns an empty Metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		families: map[string]*metricFamily{},
+	}
+}
+
+// With returns a view of m adding the given labels, as name/value pairs, to every metric registered with it
+func (m *Metrics) With(labels ...string) *Metrics {
+	if m == nil {
+		return nil
+	}
+	if m.root != nil {
+		return m.root.With(append(append([]string{}, m.labels...), labels...)...)
+	}
+	return &Metrics{root: m, labels: labels}
+}
+
+// Counter returns the counter with the given name and labels, given as name/value pairs, creating it if needed
+func (m *Metrics) Counter(name, help string, labels ...string) *Counter {
+	if m == nil {
+		return nil
+	}
+	if m.root != nil {
+		return m.root.Counter(name, help, append(append([]string{}, m.labels...), labels...)...)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	f := m.family(name, help, "counter")
+	key := formatLabels(labels)
+	c, ok := f.counters[key]
+	if !ok {
+		c = &Counter{}
+		f.counters[key] = c
+	}
+	return c
+}
+
+// Gauge registers fn to be sampled as the gauge with the given name and labels whenever metrics are written
+func (m *Metrics) Gauge(name, help string, fn func() float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	if m.root != nil {
+		m.root.Gauge(name, help, fn, append(append([]string{}, m.labels...), labels...)...)
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.family(name, help, "gauge").gauges[formatLabels(labels)] = fn
+}
+
+func (m *Metrics) family(name, help, kind string) *metricFamily {
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{
+			name:     name,
+			help:     help,
+			kind:     kind,
+			counters: map[string]*Counter{},
+			gauges:   map[string]func() float64{},
+		}
+		m.families[name] = f
+	}
+	return f
+}
+
+// WriteTo writes all metrics to w in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m.root != nil {
+		return m.root.WriteTo(w)
+	}
+
+	m.lock.Lock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := m.families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+		values := map[string]float64{}
+		for labels, c := range f.counters {
+			values[labels] = c.Value()
+		}
+		for labels, fn := range f.gauges {
+			values[labels] = fn()
+		}
+		keys := make([]string, 0, len(values))
+		for labels := range values {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+		for _, labels := range keys {
+			fmt.Fprintf(&b, "%s%s %v\n", f.name, labels, values[labels])
+		}
+	}
+	m.lock.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", MetricsContentType)
+	m.WriteTo(w)
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds delta, which must not be negative, to the counter
+func (c *Counter) Add(delta float64) {
+	if c == nil {
+		return
+	}
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&c.bits, old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() float64 {
+	if c == nil {
+		return 0
+	}
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
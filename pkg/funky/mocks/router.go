@@ -0,0 +1,177 @@
+// Code generated by mockery v1.0.0
+package mocks
+
+import funky "github.com/dispatchframework/funky/pkg/funky"
+import http "net/http"
+import json "encoding/json"
+import mock "github.com/stretchr/testify/mock"
+
+// Router is an autogenerated mock type for the Router type
+type Router struct {
+	mock.Mock
+}
+
+// Delegate provides a mock function with given fields: input
+func (_m *Router) Delegate(input *funky.Request) (*funky.Message, error) {
+	ret := _m.Called(input)
+
+	var r0 *funky.Message
+	if rf, ok := ret.Get(0).(func(*funky.Request) *funky.Message); ok {
+		r0 = rf(input)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*funky.Message)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*funky.Request) error); ok {
+		r1 = rf(input)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DelegateStream provides a mock function with given fields: input, chunk
+func (_m *Router) DelegateStream(input *funky.Request, chunk func(json.RawMessage) error) (*funky.Message, error) {
+	ret := _m.Called(input, chunk)
+
+	var r0 *funky.Message
+	if rf, ok := ret.Get(0).(func(*funky.Request, func(json.RawMessage) error) *funky.Message); ok {
+		r0 = rf(input, chunk)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*funky.Message)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*funky.Request, func(json.RawMessage) error) error); ok {
+		r1 = rf(input, chunk)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DrainServer provides a mock function with given fields: port
+func (_m *Router) DrainServer(port uint16) error {
+	ret := _m.Called(port)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint16) error); ok {
+		r0 = rf(port)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Forward provides a mock function with given fields: w, r
+func (_m *Router) Forward(w http.ResponseWriter, r *http.Request) (*funky.Message, error) {
+	ret := _m.Called(w, r)
+
+	var r0 *funky.Message
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request) *funky.Message); ok {
+		r0 = rf(w, r)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*funky.Message)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request) error); ok {
+		r1 = rf(w, r)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Pause provides a mock function with given fields:
+func (_m *Router) Pause() {
+	_m.Called()
+}
+
+// Pools provides a mock function with given fields:
+func (_m *Router) Pools() []funky.PoolStatus {
+	ret := _m.Called()
+
+	var r0 []funky.PoolStatus
+	if rf, ok := ret.Get(0).(func() []funky.PoolStatus); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]funky.PoolStatus)
+		}
+	}
+
+	return r0
+}
+
+// Reload provides a mock function with given fields:
+func (_m *Router) Reload() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Resize provides a mock function with given fields: numServers
+func (_m *Router) Resize(numServers int) error {
+	ret := _m.Called(numServers)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int) error); ok {
+		r0 = rf(numServers)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestartServer provides a mock function with given fields: port
+func (_m *Router) RestartServer(port uint16) error {
+	ret := _m.Called(port)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint16) error); ok {
+		r0 = rf(port)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Resume provides a mock function with given fields:
+func (_m *Router) Resume() {
+	_m.Called()
+}
+
+// Shutdown provides a mock function with given fields:
+func (_m *Router) Shutdown() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -2,13 +2,44 @@
 package mocks
 
 import funky "github.com/dispatchframework/funky/pkg/funky"
+import http "net/http"
+import json "encoding/json"
 import mock "github.com/stretchr/testify/mock"
+import time "time"
 
 // Server is an autogenerated mock type for the Server type
 type Server struct {
 	mock.Mock
 }
 
+// Forward provides a mock function with given fields: input, w, r
+func (_m *Server) Forward(input *funky.Request, w http.ResponseWriter, r *http.Request) error {
+	ret := _m.Called(input, w, r)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*funky.Request, http.ResponseWriter, *http.Request) error); ok {
+		r0 = rf(input, w, r)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetPID provides a mock function with given fields:
+func (_m *Server) GetPID() int {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
 // GetPort provides a mock function with given fields:
 func (_m *Server) GetPort() uint16 {
 	ret := _m.Called()
@@ -46,6 +77,36 @@ func (_m *Server) Invoke(input *funky.Request) (interface{}, error) {
 	return r0, r1
 }
 
+// InvokeStream provides a mock function with given fields: input, chunk
+func (_m *Server) InvokeStream(input *funky.Request, chunk func(json.RawMessage) error) error {
+	ret := _m.Called(input, chunk)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*funky.Request, func(json.RawMessage) error) error); ok {
+		r0 = rf(input, chunk)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Records provides a mock function with given fields:
+func (_m *Server) Records() []funky.LogRecord {
+	ret := _m.Called()
+
+	var r0 []funky.LogRecord
+	if rf, ok := ret.Get(0).(func() []funky.LogRecord); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]funky.LogRecord)
+		}
+	}
+
+	return r0
+}
+
 // Shutdown provides a mock function with given fields:
 func (_m *Server) Shutdown() error {
 	ret := _m.Called()
@@ -119,3 +180,31 @@ func (_m *Server) Terminate() error {
 
 	return r0
 }
+
+// Truncated provides a mock function with given fields:
+func (_m *Server) Truncated() int {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// WaitReady provides a mock function with given fields: deadline
+func (_m *Server) WaitReady(deadline time.Time) error {
+	ret := _m.Called(deadline)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(time.Time) error); ok {
+		r0 = rf(deadline)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,374 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ServerState the state of a server in the pool of a Router
+type ServerState string
+
+// The states of a server
+const (
+	ServerStarting ServerState = "starting"
+	ServerIdle     ServerState = "idle"
+	ServerBusy     ServerState = "busy"
+	// ServerDraining a server that receives no further invocations and is taken out of the pool once idle
+	ServerDraining ServerState = "draining"
+)
+
+// ServerStatus describes a server of a Router
+type ServerStatus struct {
+	Port  uint16      `json:"port"`
+	PID   int         `json:"pid,omitempty"`
+	State ServerState `json:"state"`
+	// Since when the server is in its state
+	Since time.Time `json:"since"`
+	// InvocationID the invocation the server is running, if busy
+	InvocationID string `json:"invocationId,omitempty"`
+	// Invocations, Failures and Restarts count the invocations run on the port, those that failed, and how often
+	// the server on the port was replaced
+	Invocations uint64 `json:"invocations"`
+	Failures    uint64 `json:"failures"`
+	Restarts    uint64 `json:"restarts"`
+}
+
+// PoolStatus describes the pool of servers of a Router
+type PoolStatus struct {
+	// Function the name of the function the servers run, if the Router hosts several
+	Function string `json:"function,omitempty"`
+	// Size the number of servers of the pool, including those not started yet if they are started on demand
+	Size    int            `json:"size"`
+	MaxSize int            `json:"maxSize"`
+	Paused  bool           `json:"paused"`
+	Pending int64          `json:"pending"`
+	Servers []ServerStatus `json:"servers"`
+}
+
+// serverInfo what a DefaultRouter tracks of the server on a port
+type serverInfo struct {
+	port uint16
+	// server is nil while it is starting
+	server       Server
+	generation   uint64
+	state        ServerState
+	since        time.Time
+	invocationID string
+	draining     bool
+
+	invocations, failures, restarts uint64
+}
+
+func (i *serverInfo) status() ServerStatus {
+	status := ServerStatus{
+		Port:         i.port,
+		State:        i.state,
+		Since:        i.since,
+		InvocationID: i.invocationID,
+		Invocations:  i.invocations,
+		Failures:     i.failures,
+		Restarts:     i.restarts,
+	}
+	if i.server != nil {
+		status.PID = i.server.GetPID()
+	}
+	if i.draining {
+		status.State = ServerDraining
+	}
+	return status
+}
+
+// reservePort records a server starting on port; the caller holds the mutex
+func (r *DefaultRouter) reservePort(port uint16) *serverInfo {
+	info := &serverInfo{
+		port:       port,
+		generation: r.generation,
+		state:      ServerStarting,
+		since:      time.Now(),
+	}
+	r.ports[port] = info
+	return info
+}
+
+// freePort returns the first port without a server; the caller holds the mutex
+func (r *DefaultRouter) freePort() uint16 {
+	port := r.options.FirstPort
+	for _, used := r.ports[port]; used; _, used = r.ports[port] {
+		port++
+	}
+	return port
+}
+
+// trackServer records server as the idle server on the port of info; the caller holds the mutex
+func (r *DefaultRouter) trackServer(info *serverInfo, server Server) {
+	info.server = server
+	info.state, info.since, info.invocationID = ServerIdle, time.Now(), ""
+	r.info[server] = info
+}
+
+// untrackServer records that server, which is out of the pool, is being replaced on its port
+func (r *DefaultRouter) untrackServer(server Server) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	info := r.info[server]
+	delete(r.info, server)
+	info.server = nil
+	info.state, info.since, info.invocationID = ServerStarting, time.Now(), ""
+}
+
+// idleServer returns the index of the last idle server that is neither in skip nor draining, -1 if there is none;
+// the caller holds the mutex
+func (r *DefaultRouter) idleServer(skip map[Server]bool) int {
+	i := len(r.servers) - 1
+	for ; i >= 0 && (skip[r.servers[i]] || r.info[r.servers[i]].draining); i-- {
+	}
+	return i
+}
+
+// Pools returns the status of the pool of servers
+func (r *DefaultRouter) Pools() []PoolStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := PoolStatus{
+		Size:    r.size,
+		MaxSize: r.maxServers,
+		Paused:  r.paused,
+		Pending: atomic.LoadInt64(&r.pending),
+		Servers: make([]ServerStatus, 0, len(r.ports)),
+	}
+	for _, info := range r.ports {
+		status.Servers = append(status.Servers, info.status())
+	}
+	sort.Slice(status.Servers, func(i, j int) bool {
+		return status.Servers[i].Port < status.Servers[j].Port
+	})
+
+	return []PoolStatus{status}
+}
+
+// Pause stops handing invocations to servers until Resume is called. Invocations in flight complete, new ones wait.
+func (r *DefaultRouter) Pause() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.paused = true
+}
+
+// Resume hands invocations to servers again after Pause
+func (r *DefaultRouter) Resume() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.paused = false
+	r.resumed.Broadcast()
+}
+
+func (r *DefaultRouter) isPaused() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.paused
+}
+
+func (r *DefaultRouter) waitResumed() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for r.paused {
+		r.resumed.Wait()
+	}
+}
+
+// Resize grows or shrinks the pool to numServers servers, between one more than the servers reserved for
+// priorities and the MaxServers of the router. New servers
+// join the pool once they are warmed up and listening, unless they are started on demand; servers are removed once
+// idle.
+func (r *DefaultRouter) Resize(numServers int) error {
+	if numServers < r.minServers() || numServers > r.maxServers {
+		return IllegalArgumentError(fmt.Sprintf("numServers must be between %d and %d", r.minServers(), r.maxServers))
+	}
+
+	r.poolMutex.Lock()
+	defer r.poolMutex.Unlock()
+
+	r.mutex.Lock()
+	size := r.size
+	r.mutex.Unlock()
+
+	for ; size < numServers; size++ {
+		if err := r.grow(); err != nil {
+			return err
+		}
+	}
+	for ; size > numServers; size-- {
+		if err := r.shrink(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// minServers the smallest size of the pool, which leaves a server that is not reserved for priorities
+func (r *DefaultRouter) minServers() int {
+	return int(r.options.Priority.reservedAbove(math.MinInt32)) + 1
+}
+
+// setSize sets the size of the pool, which bounds the servers reserved for priorities; the caller holds the mutex
+func (r *DefaultRouter) setSize(size int) {
+	r.size = size
+	r.sem.setPool(int64(size))
+}
+
+// grow adds a server to the pool, releasing the permit held for it
+func (r *DefaultRouter) grow() error {
+	r.mutex.Lock()
+	if r.options.OnDemand {
+		r.setSize(r.size + 1)
+		r.mutex.Unlock()
+		r.sem.Release(1)
+		return nil
+	}
+	info := r.reservePort(r.freePort())
+	r.numServers++
+	r.mutex.Unlock()
+
+	server, err := startServer(r.serverFactory, info.port, r.options.WarmUp)
+	if err == nil {
+		if err = server.WaitReady(time.Now().Add(defaultStartTimeout)); err != nil {
+			server.Terminate()
+		}
+	}
+
+	r.mutex.Lock()
+	if err != nil {
+		delete(r.ports, info.port)
+		r.numServers--
+		r.mutex.Unlock()
+		return err
+	}
+	r.trackServer(info, server)
+	r.setSize(r.size + 1)
+	r.mutex.Unlock()
+
+	r.releaseServer(server)
+	return nil
+}
+
+// shrink removes a server from the pool once one is idle, holding on to its permit
+func (r *DefaultRouter) shrink() error {
+	r.sem.Acquire(maxPriority)
+
+	r.mutex.Lock()
+	r.setSize(r.size - 1)
+	// with servers started on demand, the pool may have fewer servers than its size
+	if r.numServers <= r.size {
+		r.mutex.Unlock()
+		return nil
+	}
+
+	i := r.idleServer(nil)
+	for ; i < 0; i = r.idleServer(nil) {
+		r.releasedCond.Wait()
+	}
+	server := r.servers[i]
+	r.servers = append(r.servers[:i], r.servers[i+1:]...)
+	r.removeServer(server)
+	r.mutex.Unlock()
+
+	server.Terminate()
+	return nil
+}
+
+// removeServer forgets server, which was taken out of the pool; the caller holds the mutex
+func (r *DefaultRouter) removeServer(server Server) {
+	delete(r.ports, r.info[server].port)
+	delete(r.info, server)
+	r.numServers--
+	r.released++
+	r.releasedCond.Broadcast()
+}
+
+// DrainServer removes the server on port from the pool once its invocation in flight completed, and stops it. The
+// server receives no invocations meanwhile. The pool shrinks by one server.
+func (r *DefaultRouter) DrainServer(port uint16) error {
+	r.poolMutex.Lock()
+	defer r.poolMutex.Unlock()
+
+	// the size only changes while holding the poolMutex
+	r.mutex.Lock()
+	size := r.size
+	r.mutex.Unlock()
+	if size <= r.minServers() {
+		return IllegalArgumentError(fmt.Sprintf("the pool keeps at least %d of its servers", r.minServers()))
+	}
+
+	server, err := r.takeServerOn(port)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.setSize(r.size - 1)
+	r.removeServer(server)
+	r.mutex.Unlock()
+
+	// the permit of the server is held on to, like when the pool shrinks
+	return server.Terminate()
+}
+
+// RestartServer replaces the server on port with a new one once its invocation in flight completed. The server
+// receives no invocations meanwhile.
+func (r *DefaultRouter) RestartServer(port uint16) error {
+	r.poolMutex.Lock()
+	defer r.poolMutex.Unlock()
+
+	server, err := r.takeServerOn(port)
+	if err != nil {
+		return err
+	}
+	return r.replaceServer(server)
+}
+
+// takeServerOn marks the server on port as draining, so that it receives no further invocations, and takes it out
+// of the pool once it is idle
+func (r *DefaultRouter) takeServerOn(port uint16) (Server, error) {
+	// the permit of the server, while it is out of the pool
+	r.sem.Acquire(maxPriority)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	info, ok := r.ports[port]
+	if !ok || info.server == nil || info.draining {
+		r.sem.Release(1)
+		if !ok {
+			return nil, UnknownServerError(port)
+		}
+		return nil, IllegalArgumentError(fmt.Sprintf("the server on port %d is %s", port, info.status().State))
+	}
+
+	info.draining = true
+	for {
+		for i, server := range r.servers {
+			if server == info.server {
+				r.servers = append(r.servers[:i], r.servers[i+1:]...)
+				return server, nil
+			}
+		}
+		// the server was lost while its invocation timed out
+		if r.ports[port] != info {
+			r.sem.Release(1)
+			return nil, UnknownServerError(port)
+		}
+		r.releasedCond.Wait()
+	}
+}
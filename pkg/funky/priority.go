@@ -0,0 +1,222 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PriorityContextKey the Request.Context key holding the priority of an invocation, an integer
+const PriorityContextKey = "priority"
+
+// PriorityHeader the header holding the priority of a request
+const PriorityHeader = "X-Funky-Priority"
+
+// maxPriority the priority the router waits for servers with, e.g. to replace them
+const maxPriority = math.MaxInt32
+
+// PriorityOptions how invocations of different priorities share the servers of a router. Invocations waiting for a
+// server get one in order of priority, highest first, and in order of arrival within a priority.
+type PriorityOptions struct {
+	// Reserved maps priorities to the number of servers kept for invocations of at least that priority: an invocation
+	// only gets a server if as many remain idle as are reserved for the priorities above its own.
+	Reserved map[int]int
+	// MaxWait protects invocations of low priorities from starving, if positive: invocations waiting for longer get
+	// a server before all others, longest waiting first, as far as the servers reserved for higher priorities allow.
+	MaxWait time.Duration
+}
+
+// reservedAbove returns the number of servers reserved for priorities above priority
+func (o PriorityOptions) reservedAbove(priority int) int64 {
+	reserved := int64(0)
+	for p, n := range o.Reserved {
+		if p > priority {
+			reserved += int64(n)
+		}
+	}
+	return reserved
+}
+
+// invocationPriority returns the priority in the context of input, 0 if it has none or it is not an integer
+func invocationPriority(input *Request) int {
+	switch v := input.Context[PriorityContextKey].(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < maxPriority {
+			return int(v)
+		}
+	case int:
+		if v < maxPriority {
+			return v
+		}
+	case string:
+		if p, err := strconv.ParseInt(v, 10, 32); err == nil && p < maxPriority {
+			return int(p)
+		}
+	}
+	return 0
+}
+
+// prioritySemaphore a semaphore handing its permits to the waiters of highest priority
+type prioritySemaphore struct {
+	options PriorityOptions
+
+	lock sync.Mutex
+	size int64
+	used int64
+	// pool the servers in the pool, of which reservations leave at least one to any priority
+	pool    int64
+	waiters []*priorityWaiter
+}
+
+type priorityWaiter struct {
+	priority int
+	since    time.Time
+	ready    chan struct{}
+}
+
+func newPrioritySemaphore(size int64, options PriorityOptions) *prioritySemaphore {
+	return &prioritySemaphore{
+		options: options,
+		size:    size,
+		pool:    size,
+	}
+}
+
+// Acquire waits for a permit for an invocation of the given priority
+func (s *prioritySemaphore) Acquire(priority int) {
+	s.lock.Lock()
+	w := s.enqueue(priority)
+	s.dispatch()
+	s.lock.Unlock()
+
+	<-w.ready
+}
+
+// TryAcquire takes a permit for an invocation of the given priority if one is available right away
+func (s *prioritySemaphore) TryAcquire(priority int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	w := s.enqueue(priority)
+	s.dispatch()
+	select {
+	case <-w.ready:
+		return true
+	default:
+		s.remove(w)
+		return false
+	}
+}
+
+// Release returns n permits
+func (s *prioritySemaphore) Release(n int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.used -= n
+	if s.used < 0 {
+		panic("funky: released more permits than were acquired")
+	}
+	s.dispatch()
+}
+
+// hold takes n permits without waiting, e.g. those of the servers the pool may grow by
+func (s *prioritySemaphore) hold(n int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.used += n
+}
+
+// queued returns the number of waiters
+func (s *prioritySemaphore) queued() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.waiters)
+}
+
+// setPool records the size of the pool, e.g. once it was resized
+func (s *prioritySemaphore) setPool(pool int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pool = pool
+	s.dispatch()
+}
+
+// reservedAbove returns the servers reserved for priorities above priority, clamped so that the reservations never
+// take every server of the pool; the caller holds the lock
+func (s *prioritySemaphore) reservedAbove(priority int) int64 {
+	reserved := s.options.reservedAbove(priority)
+	if reserved > s.pool-1 {
+		reserved = s.pool - 1
+	}
+	if reserved < 0 {
+		reserved = 0
+	}
+	return reserved
+}
+
+func (s *prioritySemaphore) enqueue(priority int) *priorityWaiter {
+	w := &priorityWaiter{
+		priority: priority,
+		since:    time.Now(),
+		ready:    make(chan struct{}),
+	}
+	s.waiters = append(s.waiters, w)
+	return w
+}
+
+func (s *prioritySemaphore) remove(w *priorityWaiter) {
+	for i, waiter := range s.waiters {
+		if waiter == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch hands the free permits to the waiters first in line that the reserved servers allow; the caller holds
+// the lock
+func (s *prioritySemaphore) dispatch() {
+	now := time.Now()
+	for s.used < s.size {
+		var next *priorityWaiter
+		for _, w := range s.waiters {
+			if s.size-s.used > s.reservedAbove(w.priority) && (next == nil || s.before(w, next, now)) {
+				next = w
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		s.remove(next)
+		s.used++
+		close(next.ready)
+	}
+}
+
+// before returns whether a is served before b: waiters that waited longer than MaxWait come first, the longest
+// waiting first, then waiters of higher priority, and the longest waiting within a priority
+func (s *prioritySemaphore) before(a, b *priorityWaiter, now time.Time) bool {
+	if s.options.MaxWait > 0 {
+		aStarving, bStarving := now.Sub(a.since) > s.options.MaxWait, now.Sub(b.since) > s.options.MaxWait
+		if aStarving != bStarving {
+			return aStarving
+		}
+		if aStarving {
+			return a.since.Before(b.since)
+		}
+	}
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.since.Before(b.since)
+}
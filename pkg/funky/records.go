@@ -0,0 +1,147 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Levels of LogRecords
+const (
+	DebugLevel = "debug"
+	InfoLevel  = "info"
+	WarnLevel  = "warn"
+	ErrorLevel = "error"
+	FatalLevel = "fatal"
+)
+
+// LogRecord a structured entry of function output. Continuation lines, such as the frames of a
+// stacktrace, are grouped into the record of the line they continue.
+type LogRecord struct {
+	Time    time.Time              `json:"time"`
+	Stream  string                 `json:"stream"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+var levelPattern = regexp.MustCompile(`(?i)^\W{0,2}(debug|trace|info|notice|warn|warning|error|err|critical|crit|fatal|panic)\b`)
+
+var levelNames = map[string]string{
+	"trace":    DebugLevel,
+	"debug":    DebugLevel,
+	"info":     InfoLevel,
+	"notice":   InfoLevel,
+	"warn":     WarnLevel,
+	"warning":  WarnLevel,
+	"err":      ErrorLevel,
+	"error":    ErrorLevel,
+	"crit":     FatalLevel,
+	"critical": FatalLevel,
+	"fatal":    FatalLevel,
+	"panic":    FatalLevel,
+}
+
+// buildRecords groups the lines of a stream into LogRecords
+func buildRecords(stream string, lines []capturedLine) []LogRecord {
+	records := []LogRecord{}
+	// grouping tells whether the last record may still take continuation lines, traceback whether it is a Python traceback
+	grouping, traceback := false, false
+
+	for _, line := range lines {
+		if grouping && isContinuation(line.text) {
+			last := &records[len(records)-1]
+			last.Message += "\n" + line.text
+			continue
+		}
+
+		// a Python traceback ends with the unindented exception line
+		if grouping && traceback && strings.TrimSpace(line.text) != "" {
+			last := &records[len(records)-1]
+			last.Message += "\n" + line.text
+			grouping, traceback = false, false
+			continue
+		}
+
+		record, structured := parseRecord(stream, line)
+		records = append(records, record)
+		grouping = !structured
+		traceback = strings.HasPrefix(line.text, "Traceback (most recent call last)")
+	}
+
+	return records
+}
+
+func isContinuation(text string) bool {
+	return strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t") || strings.HasPrefix(text, "Caused by:")
+}
+
+// parseRecord returns the record for a single line, and whether the line was a JSON object
+func parseRecord(stream string, line capturedLine) (LogRecord, bool) {
+	record := LogRecord{
+		Time:    line.time,
+		Stream:  stream,
+		Level:   defaultLevel(stream),
+		Message: line.text,
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(line.text), "{") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line.text), &fields); err == nil {
+			record.Fields = fields
+			if msg, ok := firstString(fields, "message", "msg"); ok {
+				record.Message = msg
+			}
+			if level, ok := firstString(fields, "level", "severity", "lvl"); ok {
+				if l, ok := levelNames[strings.ToLower(level)]; ok {
+					record.Level = l
+				}
+			}
+			if ts, ok := firstString(fields, "time", "timestamp", "ts"); ok {
+				if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+					record.Time = t.UTC()
+				}
+			}
+			return record, true
+		}
+	}
+
+	if strings.HasPrefix(line.text, "Traceback (most recent call last)") {
+		record.Level = ErrorLevel
+	} else if m := levelPattern.FindStringSubmatch(line.text); m != nil {
+		record.Level = levelNames[strings.ToLower(m[1])]
+	}
+
+	return record, false
+}
+
+func defaultLevel(stream string) string {
+	if stream == StderrStream {
+		return ErrorLevel
+	}
+	return InfoLevel
+}
+
+func firstString(fields map[string]interface{}, keys ...string) (string, bool) {
+	for _, k := range keys {
+		if v, ok := fields[k].(string); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// mergeRecords merges the records of both streams in time order
+func mergeRecords(stdout, stderr []LogRecord) []LogRecord {
+	records := append(append([]LogRecord{}, stdout...), stderr...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records
+}
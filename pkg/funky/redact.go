@@ -0,0 +1,219 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RedactedMask the text secrets are replaced with
+const RedactedMask = "[REDACTED]"
+
+// minSecretLength secret values shorter than this are only masked where they make up a whole word, masking them
+// within words would mangle unrelated text
+const minSecretLength = 4
+
+// DefaultRedactionPatterns patterns for common credentials. When a pattern has a capture group, only the group is masked.
+var DefaultRedactionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`),
+	regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`),
+	regexp.MustCompile(`(?i)aws_secret_access_key["']?\s*[=:]\s*["']?([A-Za-z0-9/+=]{40})`),
+}
+
+// Redactor masks secret values and sensitive patterns in captured logs and error messages
+type Redactor struct {
+	secrets  *Secrets
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor returns a Redactor masking the values of secrets, which may be nil, and matches of patterns
+func NewRedactor(secrets *Secrets, patterns []*regexp.Regexp) *Redactor {
+	return &Redactor{
+		secrets:  secrets,
+		patterns: patterns,
+	}
+}
+
+// Redact masks secrets, the given extra secret values and pattern matches in s
+func (r *Redactor) Redact(s string, extra []string) string {
+	values, short := r.values(extra)
+	for _, v := range values {
+		s = strings.Replace(s, v, RedactedMask, -1)
+	}
+	for _, v := range short {
+		s = redactWord(s, v)
+	}
+
+	for _, p := range r.patterns {
+		s = redactPattern(s, p)
+	}
+
+	return s
+}
+
+// RedactLines redacts every line, returning a new slice
+func (r *Redactor) RedactLines(lines []string, extra []string) []string {
+	if lines == nil {
+		return nil
+	}
+
+	redacted := make([]string, len(lines))
+	for i, line := range lines {
+		redacted[i] = r.Redact(line, extra)
+	}
+	return redacted
+}
+
+// RedactRecords redacts the messages and string fields of records, returning a new slice
+func (r *Redactor) RedactRecords(records []LogRecord, extra []string) []LogRecord {
+	if records == nil {
+		return nil
+	}
+
+	redacted := make([]LogRecord, len(records))
+	for i, record := range records {
+		record.Message = r.Redact(record.Message, extra)
+		if record.Fields != nil {
+			record.Fields = r.redactValue(record.Fields, extra).(map[string]interface{})
+		}
+		redacted[i] = record
+	}
+	return redacted
+}
+
+func (r *Redactor) redactValue(v interface{}, extra []string) interface{} {
+	switch t := v.(type) {
+	case string:
+		return r.Redact(t, extra)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = r.redactValue(e, extra)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, e := range t {
+			l[i] = r.redactValue(e, extra)
+		}
+		return l
+	}
+	return v
+}
+
+// RedactError redacts the message and stacktrace of e in place
+func (r *Redactor) RedactError(e *Error, extra []string) {
+	if e == nil {
+		return
+	}
+
+	e.Message = r.Redact(e.Message, extra)
+	e.Stacktrace = r.RedactLines(e.Stacktrace, extra)
+}
+
+// values returns the secret values to mask, longest first so that overlapping secrets are fully masked, and apart
+// from them those shorter than minSecretLength
+func (r *Redactor) values(extra []string) ([]string, []string) {
+	values := []string{}
+	if r.secrets != nil {
+		for _, v := range r.secrets.Values() {
+			values = append(values, v)
+		}
+	}
+	values = append(values, extra...)
+
+	filtered, short := values[:0], []string{}
+	for _, v := range values {
+		if len(v) >= minSecretLength {
+			filtered = append(filtered, v)
+		} else if v != "" {
+			short = append(short, v)
+		}
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		return len(filtered[i]) > len(filtered[j])
+	})
+	return filtered, short
+}
+
+// redactWord masks the occurrences of v in s that are not part of a longer word
+func redactWord(s string, v string) string {
+	var b strings.Builder
+	last, from := 0, 0
+	for {
+		i := strings.Index(s[from:], v)
+		if i < 0 {
+			break
+		}
+		start, end := from+i, from+i+len(v)
+
+		before, _ := utf8.DecodeLastRuneInString(s[:start])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if isWordRune(before) || isWordRune(after) {
+			from = start + 1
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(RedactedMask)
+		last, from = end, end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func redactPattern(s string, p *regexp.Regexp) string {
+	if p.NumSubexp() == 0 {
+		return p.ReplaceAllLiteralString(s, RedactedMask)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range p.FindAllStringSubmatchIndex(s, -1) {
+		// m[2], m[3] bound the first capture group, negative when it did not participate
+		if m[2] < 0 {
+			continue
+		}
+		b.WriteString(s[last:m[2]])
+		b.WriteString(RedactedMask)
+		last = m[3]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// contextSecrets collects the string values found under SecretsContextKey in a request context
+func contextSecrets(ctx map[string]interface{}) []string {
+	values := []string{}
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch t := v.(type) {
+		case string:
+			values = append(values, t)
+		case map[string]interface{}:
+			for _, e := range t {
+				collect(e)
+			}
+		case map[string]string:
+			for _, e := range t {
+				values = append(values, e)
+			}
+		case []interface{}:
+			for _, e := range t {
+				collect(e)
+			}
+		}
+	}
+	collect(ctx[SecretsContextKey])
+	return values
+}
@@ -0,0 +1,185 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// FunctionContextKey the Request.Context key holding the name of the function to invoke
+const FunctionContextKey = "function"
+
+// FunctionHeader the header naming the function a request is for
+const FunctionHeader = "X-Funky-Function"
+
+// Registry a Router hosting several named functions, each with its own Router. Invocations are routed by
+// the function named in their context, or to the default function if they name none.
+type Registry struct {
+	routers         map[string]Router
+	defaultFunction string
+}
+
+// NewRegistry returns a Registry routing to the given routers by function name. defaultFunction, if not empty,
+// names the function invoked by requests that do not name one.
+func NewRegistry(routers map[string]Router, defaultFunction string) *Registry {
+	return &Registry{
+		routers:         routers,
+		defaultFunction: defaultFunction,
+	}
+}
+
+// Functions returns the names of the registered functions, sorted
+func (r *Registry) Functions() []string {
+	names := make([]string, 0, len(r.routers))
+	for name := range r.routers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Router returns the router of the named function
+func (r *Registry) Router(name string) (Router, bool) {
+	router, ok := r.routers[name]
+	return router, ok
+}
+
+// Delegate delegates input to the router of the function it names
+func (r *Registry) Delegate(input *Request) (*Message, error) {
+	name, _ := input.Context[FunctionContextKey].(string)
+	router, err := r.route(name)
+	if err != nil {
+		return NewErrorMessage(InputError, err), nil
+	}
+	return router.Delegate(input)
+}
+
+// DelegateStream delegates input to the router of the function it names
+func (r *Registry) DelegateStream(input *Request, chunk func(json.RawMessage) error) (*Message, error) {
+	name, _ := input.Context[FunctionContextKey].(string)
+	router, err := r.route(name)
+	if err != nil {
+		return NewErrorMessage(InputError, err), nil
+	}
+	return router.DelegateStream(input, chunk)
+}
+
+// Forward passes req through to the function named by its FunctionHeader
+func (r *Registry) Forward(w http.ResponseWriter, req *http.Request) (*Message, error) {
+	router, err := r.route(req.Header.Get(FunctionHeader))
+	if err != nil {
+		return NewErrorMessage(InputError, err), nil
+	}
+	return router.Forward(w, req)
+}
+
+// Reload reloads the routers of all functions in turn
+func (r *Registry) Reload() error {
+	var err error
+	for _, name := range r.Functions() {
+		if reloadErr := r.routers[name].Reload(); reloadErr != nil {
+			err = fmt.Errorf("Failed to reload function %s: %s", name, reloadErr)
+		}
+	}
+	return err
+}
+
+// Pools returns the pools of all functions, labelled with the function name
+func (r *Registry) Pools() []PoolStatus {
+	pools := []PoolStatus{}
+	for _, name := range r.Functions() {
+		for _, pool := range r.routers[name].Pools() {
+			pool.Function = name
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+// Pause pauses the routers of all functions
+func (r *Registry) Pause() {
+	for _, router := range r.routers {
+		router.Pause()
+	}
+}
+
+// Resume resumes the routers of all functions
+func (r *Registry) Resume() {
+	for _, router := range r.routers {
+		router.Resume()
+	}
+}
+
+// Resize resizes the pool of the default function; the pools of other functions are resized through their Router
+func (r *Registry) Resize(numServers int) error {
+	router, err := r.route("")
+	if err != nil {
+		return err
+	}
+	return router.Resize(numServers)
+}
+
+// DrainServer drains the server on port, of whichever function it runs
+func (r *Registry) DrainServer(port uint16) error {
+	router, err := r.routeServer(port)
+	if err != nil {
+		return err
+	}
+	return router.DrainServer(port)
+}
+
+// RestartServer restarts the server on port, of whichever function it runs
+func (r *Registry) RestartServer(port uint16) error {
+	router, err := r.routeServer(port)
+	if err != nil {
+		return err
+	}
+	return router.RestartServer(port)
+}
+
+// Shutdown shuts down the routers of all functions
+func (r *Registry) Shutdown() error {
+	var err error
+	for _, router := range r.routers {
+		if shutdownErr := router.Shutdown(); shutdownErr != nil {
+			err = shutdownErr
+		}
+	}
+
+	if err != nil {
+		return errors.New("Failed to shutdown one or more functions")
+	}
+
+	return nil
+}
+
+func (r *Registry) route(name string) (Router, error) {
+	if name == "" {
+		name = r.defaultFunction
+	}
+	router, ok := r.routers[name]
+	if !ok {
+		return nil, UnknownFunctionError(name)
+	}
+	return router, nil
+}
+
+// routeServer returns the router with a server on port; the ports of functions are disjoint
+func (r *Registry) routeServer(port uint16) (Router, error) {
+	for _, router := range r.routers {
+		for _, pool := range router.Pools() {
+			for _, server := range pool.Servers {
+				if server.Port == port {
+					return router, nil
+				}
+			}
+		}
+	}
+	return nil, UnknownServerError(port)
+}
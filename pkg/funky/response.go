@@ -9,6 +9,9 @@ const (
 	SystemError   = "SystemError"
 )
 
+// InvocationIDContextKey the Request.Context key holding the ID of an invocation, generated by the router unless given
+const InvocationIDContextKey = "invocationId"
+
 // Request a struct to hold the request body sent to a Dispatch function
 type Request struct {
 	Context map[string]interface{} `json:"context"`
@@ -23,9 +26,14 @@ type Message struct {
 
 // Context a struct to hold the context of a Dispatch function invocation
 type Context struct {
-	Error    *Error     `json:"error,omitempty"`
-	Logs     *Logs      `json:"logs"`
-	Deadline *time.Time `json:"deadline,omitempty"`
+	Error        *Error     `json:"error,omitempty"`
+	Logs         *Logs      `json:"logs"`
+	Deadline     *time.Time `json:"deadline,omitempty"`
+	InvocationID string     `json:"invocationId,omitempty"`
+	Cached       bool       `json:"cached,omitempty"`
+	Coalesced    bool       `json:"coalesced,omitempty"`
+	Attempts     int        `json:"attempts,omitempty"`
+	ColdStartMs  int64      `json:"coldStartMs,omitempty"`
 }
 
 // Error a struct to hold the error status of a Dispatch function invocation
@@ -33,10 +41,28 @@ type Error struct {
 	ErrorType  string   `json:"type"`
 	Message    string   `json:"message"`
 	Stacktrace []string `json:"stacktrace"`
+
+	// cause the error funky encountered, if the function did not report the error itself
+	cause error
 }
 
 // Logs a struct to hold the logs of a Dispatch function invocation
 type Logs struct {
-	Stdout []string `json:"stdout"`
-	Stderr []string `json:"stderr"`
+	Stdout    []string    `json:"stdout"`
+	Stderr    []string    `json:"stderr"`
+	Truncated int         `json:"truncated,omitempty"`
+	Records   []LogRecord `json:"records,omitempty"`
+}
+
+// NewErrorMessage returns a Message reporting err as an error of errorType
+func NewErrorMessage(errorType string, err error) *Message {
+	return &Message{
+		Context: &Context{
+			Error: &Error{
+				ErrorType: errorType,
+				Message:   err.Error(),
+				cause:     err,
+			},
+		},
+	}
 }
@@ -5,12 +5,16 @@
 package funky
 
 import (
-	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net/http"
 	"sync"
-
-	"golang.org/x/sync/semaphore"
+	"sync/atomic"
+	"time"
 )
 
 // FirstPort the starting port number for servers created by a Router
@@ -19,9 +23,27 @@ const FirstPort uint16 = 9000
 // Healthy a channel for reporting the health of the web service.
 var Healthy = make(chan struct{})
 
+var unhealthy sync.Once
+
+// markUnhealthy closes Healthy, unless it already is
+func markUnhealthy() {
+	unhealthy.Do(func() {
+		close(Healthy)
+	})
+}
+
 // Router an interface for delegating function invocations to idle servers
 type Router interface {
 	Delegate(input *Request) (*Message, error)
+	DelegateStream(input *Request, chunk func(json.RawMessage) error) (*Message, error)
+	Forward(w http.ResponseWriter, r *http.Request) (*Message, error)
+	Reload() error
+	Pools() []PoolStatus
+	Pause()
+	Resume()
+	Resize(numServers int) error
+	DrainServer(port uint16) error
+	RestartServer(port uint16) error
 	Shutdown() error
 }
 
@@ -30,71 +52,338 @@ type DefaultRouter struct {
 	servers       []Server
 	serverFactory ServerFactory
 	mutex         *sync.Mutex
-	sem           *semaphore.Weighted
+	poolMutex     sync.Mutex
+	sem           *prioritySemaphore
+	options       RouterOptions
+	pending       int64
+	retries       *Counter
+
+	numServers   int
+	size         int
+	maxServers   int
+	ports        map[uint16]*serverInfo
+	info         map[Server]*serverInfo
+	generation   uint64
+	released     uint64
+	releasedCond *sync.Cond
+	paused       bool
+	resumed      *sync.Cond
+
+	coldStarts *Counter
+	done       chan struct{}
+}
+
+// RouterOptions optional settings for a DefaultRouter
+type RouterOptions struct {
+	// Secrets are added to the context of every request under SecretsContextKey when set
+	Secrets *Secrets
+	// Redactor masks secrets in captured logs and error messages when set
+	Redactor *Redactor
+	// StructuredLogs adds the captured logs as LogRecords to every response
+	StructuredLogs bool
+	// ProxyTimeout bounds requests passed through by Forward, if positive
+	ProxyTimeout time.Duration
+	// MaxPending bounds the invocations waiting for an idle server, if positive. Invocations beyond it fail
+	// with a QueueFullError.
+	MaxPending int
+	// Retry the policy for retrying failed invocations on another server
+	Retry RetryOptions
+	// Metrics receives the metrics of the router when set
+	Metrics *Metrics
+	// WarmUp the invocations every server receives before joining the pool, at startup and when it is replaced
+	WarmUp WarmUpOptions
+	// OnDemand starts no servers with the router, but starts them as invocations need them, up to the number of
+	// servers of the router. Invocations wait for the server to start within their deadline.
+	OnDemand bool
+	// IdleTimeout stops servers started on demand once they have been idle for as long, if positive
+	IdleTimeout time.Duration
+	// ReloadReadyTimeout bounds the wait for a server replaced by Reload to listen before the next one is replaced.
+	// Replaced servers are not waited for if it is not positive.
+	ReloadReadyTimeout time.Duration
+	// MaxServers bounds the number of servers the pool may be resized to, defaults to the initial number of servers
+	MaxServers int
+	// FirstPort the port of the first server, defaults to FirstPort. Routers sharing a host need disjoint ranges
+	// of MaxServers ports.
+	FirstPort uint16
+	// DefaultTimeout bounds invocations without a deadline, if positive
+	DefaultTimeout time.Duration
+	// Priority how invocations of different priorities share the servers
+	Priority PriorityOptions
+}
+
+// RetryOptions a policy for retrying invocations that failed before the function ran
+type RetryOptions struct {
+	// MaxAttempts the number of attempts of an invocation, including the first. Invocations are not retried if it is below 2.
+	MaxAttempts int
+	// Backoff the wait before the first retry, doubled for every further one
+	Backoff time.Duration
+	// Timeouts also retries invocations that timed out, which is only safe for idempotent functions
+	Timeouts bool
+	// AttemptTimeout bounds each attempt within the deadline of the invocation, if positive
+	AttemptTimeout time.Duration
+}
+
+func (o RetryOptions) backoff(attempt int) time.Duration {
+	return o.Backoff << uint(attempt-1)
 }
 
 // NewRouter constructor for DefaultRouters
 func NewRouter(numServers int, serverFactory ServerFactory) (*DefaultRouter, error) {
+	return NewRouterWithOptions(numServers, serverFactory, RouterOptions{})
+}
+
+// NewRouterWithOptions constructor for DefaultRouters accepting RouterOptions
+func NewRouterWithOptions(numServers int, serverFactory ServerFactory, options RouterOptions) (*DefaultRouter, error) {
 	if numServers < 1 {
 		return nil, IllegalArgumentError("numServers")
 	}
 
-	servers, err := createServers(numServers, serverFactory)
-	if err != nil {
-		return nil, err
+	if options.FirstPort == 0 {
+		options.FirstPort = FirstPort
+	}
+	maxServers := numServers
+	if options.MaxServers > maxServers {
+		maxServers = options.MaxServers
+	}
+	// invocations of the lowest priority need a server that is not reserved
+	if options.Priority.reservedAbove(math.MinInt32) >= int64(numServers) {
+		return nil, IllegalArgumentError("Priority.Reserved")
 	}
 
-	return &DefaultRouter{
+	servers := []Server{}
+	if !options.OnDemand {
+		var err error
+		if servers, err = createServers(numServers, serverFactory, options.FirstPort, options.WarmUp); err != nil {
+			return nil, err
+		}
+	}
+
+	mutex := &sync.Mutex{}
+	r := &DefaultRouter{
 		servers:       servers,
 		serverFactory: serverFactory,
-		mutex:         &sync.Mutex{},
-		sem:           semaphore.NewWeighted(int64(numServers)),
-	}, nil
+		mutex:         mutex,
+		sem:           newPrioritySemaphore(int64(maxServers), options.Priority),
+		options:       options,
+		retries:       options.Metrics.Counter("funky_retries_total", "Invocations retried after failing before the function ran."),
+		numServers:    len(servers),
+		size:          numServers,
+		maxServers:    maxServers,
+		ports:         map[uint16]*serverInfo{},
+		info:          map[Server]*serverInfo{},
+		releasedCond:  sync.NewCond(mutex),
+		resumed:       sync.NewCond(mutex),
+		coldStarts:    options.Metrics.Counter("funky_cold_starts_total", "Servers started on demand for an invocation."),
+		done:          make(chan struct{}),
+	}
+	// the permits of the servers the pool may grow by are held until it is resized
+	r.sem.hold(int64(maxServers - numServers))
+	r.sem.setPool(int64(numServers))
+	for i, server := range servers {
+		r.trackServer(r.reservePort(options.FirstPort+uint16(i)), server)
+	}
+
+	options.Metrics.Gauge("funky_servers", "Function servers running.", func() float64 {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return float64(r.numServers)
+	})
+	options.Metrics.Gauge("funky_invocations_queued", "Invocations queued for a server, in order of priority.", func() float64 {
+		return float64(r.sem.queued())
+	})
+
+	if options.OnDemand && options.IdleTimeout > 0 {
+		go r.stopIdleServers()
+	}
+
+	return r, nil
 }
 
 // Delegate delegates function invocation to an idle server
 func (r *DefaultRouter) Delegate(input *Request) (*Message, error) {
-	server, err := r.findFreeServer()
-	if err != nil {
-		return nil, err
+	return r.delegate(input, func(server Server, input *Request) (interface{}, error) {
+		return server.Invoke(input)
+	}, func() bool { return true })
+}
+
+// DelegateStream delegates function invocation to an idle server, passing the response to chunk as it is streamed.
+// The returned Message holds the logs and error of the invocation, but no payload. Failed invocations are only
+// retried if no chunk was passed on yet.
+func (r *DefaultRouter) DelegateStream(input *Request, chunk func(json.RawMessage) error) (*Message, error) {
+	streamed := false
+	return r.delegate(input, func(server Server, input *Request) (interface{}, error) {
+		return nil, server.InvokeStream(input, func(c json.RawMessage) error {
+			streamed = true
+			return chunk(c)
+		})
+	}, func() bool { return !streamed })
+}
+
+// Forward proxies r as-is to an idle server and streams the response to w. The invocation ID and priority are taken
+// from the InvocationIDHeader and PriorityHeader of r, if present. The returned Message holds the logs and error of the invocation, but no payload.
+func (r *DefaultRouter) Forward(w http.ResponseWriter, req *http.Request) (*Message, error) {
+	input := &Request{Context: map[string]interface{}{}}
+	if id := req.Header.Get(InvocationIDHeader); id != "" {
+		input.Context[InvocationIDContextKey] = id
+	}
+	if priority := req.Header.Get(PriorityHeader); priority != "" {
+		input.Context[PriorityContextKey] = priority
+	}
+	if r.options.ProxyTimeout > 0 {
+		input.Context["deadline"] = time.Now().Add(r.options.ProxyTimeout).Format(time.RFC3339Nano)
+	}
+
+	// the request body cannot be sent again, so passed through requests are never retried
+	return r.delegate(input, func(server Server, input *Request) (interface{}, error) {
+		return nil, server.Forward(input, w, req)
+	}, nil)
+}
+
+// invokeFunc invokes a function on a server
+type invokeFunc func(server Server, input *Request) (interface{}, error)
+
+// delegate invokes input on an idle server, retrying on another server as configured while mayRetry, if given,
+// allows it
+func (r *DefaultRouter) delegate(input *Request, invoke invokeFunc, mayRetry func() bool) (*Message, error) {
+	id, _ := input.Context[InvocationIDContextKey].(string)
+	if id == "" {
+		id = NewInvocationID()
+		input = withContextValue(input, InvocationIDContextKey, id)
+	}
+
+	if r.options.Secrets != nil {
+		input = withSecrets(input, r.options.Secrets.Values())
+	}
+
+	deadline, hasDeadline := invocationDeadline(input)
+	if !hasDeadline && r.options.DefaultTimeout > 0 {
+		deadline, hasDeadline = time.Now().Add(r.options.DefaultTimeout), true
+		input = withContextValue(input, "deadline", deadline.Format(time.RFC3339Nano))
+	}
+	priority := invocationPriority(input)
+	tried := map[Server]bool{}
+	coldStart := time.Duration(0)
+	for attempt := 1; ; attempt++ {
+		attemptInput := input
+		if mayRetry != nil && r.options.Retry.AttemptTimeout > 0 {
+			attemptDeadline := time.Now().Add(r.options.Retry.AttemptTimeout)
+			if !hasDeadline || attemptDeadline.Before(deadline) {
+				attemptInput = withContextValue(input, "deadline", attemptDeadline.Format(time.RFC3339Nano))
+			}
+		}
+
+		server, started, err := r.findFreeServer(priority, tried, deadline)
+		if err != nil {
+			return nil, err
+		}
+		tried[server] = true
+		coldStart += started
+
+		resp, logs, err := r.invokeOn(server, attemptInput, invoke)
+		if err == nil || mayRetry == nil || !mayRetry() || !r.shouldRetry(err, attempt, deadline, hasDeadline) {
+			msg := r.message(input, id, resp, logs, err, attempt)
+			msg.Context.ColdStartMs = coldStart.Nanoseconds() / int64(time.Millisecond)
+			return msg, nil
+		}
+
+		r.retries.Inc()
+		time.Sleep(r.options.Retry.backoff(attempt))
 	}
+}
 
+// invokeOn invokes input on server, which is released afterwards, or replaced if the invocation timed out
+func (r *DefaultRouter) invokeOn(server Server, input *Request, invoke invokeFunc) (interface{}, Logs, error) {
 	defer func() {
 		if server != nil {
 			r.releaseServer(server)
 		}
 	}()
 
-	var e *Error
-	resp, err := server.Invoke(input)
+	id, _ := input.Context[InvocationIDContextKey].(string)
+	r.mutex.Lock()
+	info := r.info[server]
+	info.state, info.since, info.invocationID = ServerBusy, time.Now(), id
+	info.invocations++
+	r.mutex.Unlock()
+
+	resp, err := invoke(server, input)
 
 	logs := Logs{
-		Stdout: server.Stdout(),
-		Stderr: server.Stderr(),
+		Stdout:    server.Stdout(),
+		Stderr:    server.Stderr(),
+		Truncated: server.Truncated(),
+	}
+	if r.options.StructuredLogs {
+		logs.Records = server.Records()
 	}
 
 	if err != nil {
-		switch v := err.(type) {
-		case TimeoutError:
-			defer func() {
-				if r := recover(); r != nil {
-					fmt.Println("recovered", r)
-				}
-			}()
-			terminateErr := server.Terminate()
-			newServer, serverErr := r.serverFactory.CreateServer(server.GetPort())
-			server = nil
-			if serverErr != nil || terminateErr != nil {
-				close(Healthy)
+		r.mutex.Lock()
+		info.failures++
+		r.mutex.Unlock()
+	}
+
+	if _, ok := err.(TimeoutError); ok {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Println("recovered", r)
+			}
+		}()
+		port := server.GetPort()
+		r.untrackServer(server)
+		terminateErr := server.Terminate()
+		newServer, serverErr := startServer(r.serverFactory, port, r.options.WarmUp)
+		server = nil
+		if serverErr != nil || terminateErr != nil {
+			r.loseServer(port)
+			// servers started on demand are replaced by the next invocation needing one, which needs the permit
+			if r.options.OnDemand {
+				r.sem.Release(1)
 			} else {
-				if newServer.Start() != nil {
-					close(Healthy)
-				}
-				server = newServer
+				markUnhealthy()
 			}
+		} else {
+			r.mutex.Lock()
+			r.trackServer(info, newServer)
+			info.restarts++
+			r.mutex.Unlock()
+			server = newServer
+		}
+	}
+
+	return resp, logs, err
+}
+
+// shouldRetry returns whether an invocation failing with err on the given attempt is retried. Only errors that
+// occur before the function ran are retried, and timeouts if configured, as long as the deadline allows.
+func (r *DefaultRouter) shouldRetry(err error, attempt int, deadline time.Time, hasDeadline bool) bool {
+	if attempt >= r.options.Retry.MaxAttempts {
+		return false
+	}
+
+	switch err.(type) {
+	case ConnectionRefusedError, UnknownSystemError:
+	case TimeoutError:
+		if !r.options.Retry.Timeouts {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return !hasDeadline || time.Now().Add(r.options.Retry.backoff(attempt)).Before(deadline)
+}
+
+// message returns the Message of an invocation of input that returned resp or failed with err
+func (r *DefaultRouter) message(input *Request, id string, resp interface{}, logs Logs, err error, attempts int) *Message {
+	var e *Error
+	if err != nil {
+		switch v := err.(type) {
+		case TimeoutError:
 			e = &Error{
 				ErrorType: FunctionError,
 				Message:   err.Error(),
+				cause:     err,
 			}
 		case FunctionServerError:
 			e = &v.APIError
@@ -102,27 +391,48 @@ func (r *DefaultRouter) Delegate(input *Request) (*Message, error) {
 			e = &Error{
 				ErrorType: SystemError,
 				Message:   err.Error(),
+				cause:     err,
 			}
 		}
 	}
 
+	if r.options.Redactor != nil {
+		extra := contextSecrets(input.Context)
+		logs.Stdout = r.options.Redactor.RedactLines(logs.Stdout, extra)
+		logs.Stderr = r.options.Redactor.RedactLines(logs.Stderr, extra)
+		logs.Records = r.options.Redactor.RedactRecords(logs.Records, extra)
+		r.options.Redactor.RedactError(e, extra)
+	}
+
 	ctx := Context{
-		Error: e,
-		Logs:  &logs,
+		Error:        e,
+		Logs:         &logs,
+		InvocationID: id,
+	}
+	if attempts > 1 {
+		ctx.Attempts = attempts
 	}
 
-	response := &Message{
+	return &Message{
 		Context: &ctx,
 		Payload: resp,
 	}
-
-	return response, nil
 }
 
 // Shutdown shuts down the servers managed by this router
 func (r *DefaultRouter) Shutdown() error {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+
+	r.mutex.Lock()
+	servers := append([]Server{}, r.servers...)
+	r.mutex.Unlock()
+
 	var err error
-	for _, server := range r.servers {
+	for _, server := range servers {
 		err = server.Shutdown()
 	}
 
@@ -133,43 +443,311 @@ func (r *DefaultRouter) Shutdown() error {
 	return nil
 }
 
-func createServers(numServers int, serverFactory ServerFactory) ([]Server, error) {
-	servers := []Server{}
-	for i := uint16(0); i < uint16(numServers); i++ {
-		server, err := serverFactory.CreateServer(FirstPort + i)
-		if err != nil {
-			return nil, fmt.Errorf("Failed creating server on port %d", FirstPort+i)
+// invocationDeadline returns the deadline in the context of input, if it has a valid one
+func invocationDeadline(input *Request) (time.Time, bool) {
+	dl, ok := input.Context["deadline"].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, dl)
+	return t, err == nil
+}
+
+// NewInvocationID returns a random identifier for an invocation
+func NewInvocationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withSecrets returns a copy of input with secrets merged into its context, leaving the caller's request untouched
+func withSecrets(input *Request, secrets map[string]string) *Request {
+	merged := map[string]interface{}{}
+	if existing, ok := input.Context[SecretsContextKey].(map[string]interface{}); ok {
+		for k, v := range existing {
+			merged[k] = v
 		}
-		if err := server.Start(); err != nil {
-			return nil, fmt.Errorf("Failed to start server %+v", err)
+	}
+	for k, v := range secrets {
+		merged[k] = v
+	}
+
+	return withContextValue(input, SecretsContextKey, merged)
+}
+
+func withContextValue(input *Request, key string, value interface{}) *Request {
+	ctx := make(map[string]interface{}, len(input.Context)+1)
+	for k, v := range input.Context {
+		ctx[k] = v
+	}
+	ctx[key] = value
+
+	return &Request{
+		Context: ctx,
+		Payload: input.Payload,
+	}
+}
+
+// createServers starts the servers, which are warmed up concurrently
+func createServers(numServers int, serverFactory ServerFactory, firstPort uint16, warmUp WarmUpOptions) ([]Server, error) {
+	servers := make([]Server, numServers)
+	errs := make([]error, numServers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numServers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			servers[i], errs[i] = startServer(serverFactory, firstPort+uint16(i), warmUp)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			for _, server := range servers {
+				if server != nil {
+					server.Terminate()
+				}
+			}
+			return nil, err
 		}
-		servers = append(servers, server)
 	}
 
 	return servers, nil
 }
 
-func (r *DefaultRouter) findFreeServer() (Server, error) {
-	if err := r.sem.Acquire(context.TODO(), 1); err != nil {
-		return nil, err
+// findFreeServer waits for an idle server not in tried, or for any idle server once all have been tried, after
+// the invocations of higher priority waiting for one.
+// If servers are started on demand and there is none to use, a server is started, waiting until deadline, if
+// not zero, for it to listen; the time this took is returned.
+func (r *DefaultRouter) findFreeServer(priority int, tried map[Server]bool, deadline time.Time) (Server, time.Duration, error) {
+	if r.isPaused() || !r.sem.TryAcquire(priority) {
+		pending := atomic.AddInt64(&r.pending, 1)
+		defer atomic.AddInt64(&r.pending, -1)
+		if r.options.MaxPending > 0 && pending > int64(r.options.MaxPending) {
+			return nil, 0, QueueFullError(fmt.Sprintf("%d invocations are waiting for a server", r.options.MaxPending))
+		}
+
+		r.waitResumed()
+		r.sem.Acquire(priority)
+	}
+
+	// if we're here, it's guaranteed we have at least one element in servers, or room to start one on demand
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for {
+		i := r.idleServer(tried)
+		if i < 0 && r.options.OnDemand && r.numServers < r.size {
+			return r.startOnDemand(deadline)
+		}
+		if i < 0 && len(tried) >= r.numServers {
+			i = r.idleServer(nil)
+		}
+
+		if i >= 0 {
+			server := r.servers[i]
+			r.servers = append(r.servers[:i], r.servers[i+1:]...)
+			return server, 0, nil
+		}
+
+		// only servers already tried are idle, wait for another one without holding on to them
+		released := r.released
+		r.sem.Release(1)
+		for r.released == released {
+			r.releasedCond.Wait()
+		}
+		r.mutex.Unlock()
+		r.sem.Acquire(priority)
+		r.mutex.Lock()
+	}
+}
+
+// startOnDemand starts a server on a free port for the caller of findFreeServer, which holds the mutex
+func (r *DefaultRouter) startOnDemand(deadline time.Time) (Server, time.Duration, error) {
+	info := r.reservePort(r.freePort())
+	port := info.port
+	r.numServers++
+
+	r.mutex.Unlock()
+	start := time.Now()
+	if deadline.IsZero() {
+		deadline = start.Add(defaultStartTimeout)
+	}
+	server, err := startServer(r.serverFactory, port, r.options.WarmUp)
+	if err == nil {
+		if err = server.WaitReady(deadline); err != nil {
+			server.Terminate()
+		}
+	}
+	r.coldStarts.Inc()
+	r.mutex.Lock()
+
+	if err != nil {
+		delete(r.ports, port)
+		r.numServers--
+		r.sem.Release(1)
+		return nil, 0, err
+	}
+	r.trackServer(info, server)
+	return server, time.Since(start), nil
+}
+
+// stopIdleServers stops the servers that have been idle for longer than the IdleTimeout until the router shuts down
+func (r *DefaultRouter) stopIdleServers() {
+	ticker := time.NewTicker(r.options.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+
+		r.mutex.Lock()
+		idle := []Server{}
+		servers := r.servers[:0]
+		for _, server := range r.servers {
+			if info := r.info[server]; !info.draining && time.Since(info.since) >= r.options.IdleTimeout {
+				idle = append(idle, server)
+				delete(r.info, server)
+				delete(r.ports, info.port)
+				r.numServers--
+			} else {
+				servers = append(servers, server)
+			}
+		}
+		r.servers = servers
+		if len(idle) > 0 {
+			r.released++
+			r.releasedCond.Broadcast()
+		}
+		r.mutex.Unlock()
+
+		for _, server := range idle {
+			server.Terminate()
+		}
 	}
+}
 
-	// if we're here, it's guaranteed we have at least one element in servers
+// Reload replaces the servers one at a time with servers started by the ServerFactory, so that they run the current
+// function code. Every server is replaced once it is idle, and is not used again meanwhile; the others keep serving.
+// Reload stops at the first server that cannot be replaced.
+func (r *DefaultRouter) Reload() error {
+	r.poolMutex.Lock()
+	defer r.poolMutex.Unlock()
+
+	r.mutex.Lock()
+	r.generation++
+	generation := r.generation
+	r.mutex.Unlock()
+
+	for {
+		server, err := r.drainServer(generation)
+		if server == nil || err != nil {
+			return err
+		}
+		if err := r.replaceServer(server); err != nil {
+			return err
+		}
+	}
+}
+
+// drainServer takes a server started before the given generation out of the pool once it is idle, waiting for
+// one if they are all busy. It returns nil once all servers are of the generation.
+func (r *DefaultRouter) drainServer(generation uint64) (Server, error) {
+	r.sem.Acquire(maxPriority)
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	server := r.servers[len(r.servers)-1]
-	r.servers = r.servers[:len(r.servers)-1]
+	for {
+		for i := len(r.servers) - 1; i >= 0; i-- {
+			if server := r.servers[i]; !r.info[server].draining && r.info[server].generation < generation {
+				r.servers = append(r.servers[:i], r.servers[i+1:]...)
+				return server, nil
+			}
+		}
+
+		old := false
+		for _, info := range r.ports {
+			old = old || info.generation < generation
+		}
+		if !old {
+			r.sem.Release(1)
+			return nil, nil
+		}
+
+		// the remaining servers to replace are busy, wait for one without holding on to the idle ones
+		released := r.released
+		r.sem.Release(1)
+		for r.released == released {
+			r.releasedCond.Wait()
+		}
+		r.mutex.Unlock()
+		r.sem.Acquire(maxPriority)
+		r.mutex.Lock()
+	}
+}
+
+// replaceServer stops server, which was taken out of the pool, and adds a new server started on its port to the
+// pool, of the current generation
+func (r *DefaultRouter) replaceServer(server Server) error {
+	r.mutex.Lock()
+	info := r.info[server]
+	r.mutex.Unlock()
+	port := info.port
+	r.untrackServer(server)
+
+	server.Terminate()
+	newServer, err := startServer(r.serverFactory, port, r.options.WarmUp)
+	if err == nil && r.options.ReloadReadyTimeout > 0 {
+		if err = newServer.WaitReady(time.Now().Add(r.options.ReloadReadyTimeout)); err != nil {
+			newServer.Terminate()
+		}
+	}
+	if err != nil {
+		r.loseServer(port)
+		r.sem.Release(1)
+		if !r.options.OnDemand {
+			markUnhealthy()
+		}
+		return fmt.Errorf("Failed to replace server on port %d: %s", port, err)
+	}
+
+	r.mutex.Lock()
+	r.trackServer(info, newServer)
+	info.generation = r.generation
+	info.draining = false
+	info.restarts++
+	r.mutex.Unlock()
+	r.releaseServer(newServer)
+	return nil
+}
+
+// loseServer accounts for a server that could not be replaced, so that retries stop waiting for it
+func (r *DefaultRouter) loseServer(port uint16) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	return server, nil
+	delete(r.ports, port)
+	r.numServers--
+	r.released++
+	r.releasedCond.Broadcast()
 }
 
 func (r *DefaultRouter) releaseServer(server Server) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	info := r.info[server]
+	info.state, info.since, info.invocationID = ServerIdle, time.Now(), ""
 	r.servers = append(r.servers, server)
+	r.released++
+	r.releasedCond.Broadcast()
 
 	r.sem.Release(1)
 }
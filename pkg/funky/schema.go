@@ -0,0 +1,603 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema a compiled JSON Schema. The validation keywords of draft 4 to 7 are supported, except for those in
+// unsupportedKeywords, which ParseSchema rejects; $ref is supported for references within the document.
+type Schema struct {
+	always *bool
+
+	types             []string
+	properties        map[string]*Schema
+	patternProperties map[*regexp.Regexp]*Schema
+	additional        *Schema
+	required          []string
+	items             *Schema
+	tupleItems        []*Schema
+	additionalItems   *Schema
+	enum              []interface{}
+	constant          *interface{}
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength, maxLength         *int
+	minItems, maxItems           *int
+	minProperties, maxProperties *int
+	uniqueItems                  bool
+	pattern                      *regexp.Regexp
+
+	allOf, anyOf, oneOf []*Schema
+	not                 *Schema
+}
+
+// unsupportedKeywords the validation keywords of draft 4 and later a Schema does not check. Schemas using them are
+// rejected, as ignoring them would let values through that the schema forbids.
+var unsupportedKeywords = []string{
+	"contains", "dependencies", "dependentRequired", "dependentSchemas", "else", "format", "if", "maxContains",
+	"minContains", "prefixItems", "propertyNames", "then", "unevaluatedItems", "unevaluatedProperties",
+}
+
+// SchemaViolation a single reason for a value not matching a Schema
+type SchemaViolation struct {
+	// Path the JSON pointer of the offending value
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// SchemaError an error listing the violations of a value not matching a Schema
+type SchemaError []SchemaViolation
+
+func (e SchemaError) Error() string {
+	messages := make([]string, len(e))
+	for i, v := range e {
+		path := v.Path
+		if path == "" {
+			path = "/"
+		}
+		messages[i] = fmt.Sprintf("%s: %s", path, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// LoadSchema reads and compiles the JSON Schema in the file at path
+func LoadSchema(path string) (*Schema, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchema(b)
+}
+
+// ParseSchema compiles the JSON Schema doc
+func ParseSchema(doc []byte) (*Schema, error) {
+	var raw interface{}
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return nil, IllegalArgumentError(fmt.Sprintf("schema is not valid JSON: %s", err))
+	}
+
+	c := &schemaCompiler{
+		root: raw,
+		refs: map[string]*Schema{},
+	}
+	s := &Schema{}
+	if err := c.compile(raw, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate returns a SchemaError if value does not match the schema
+func (s *Schema) Validate(value interface{}) error {
+	var violations SchemaError
+	s.validate(normalizeJSON(value), "", &violations)
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+func (s *Schema) validate(v interface{}, path string, violations *SchemaError) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			fail("no value is allowed")
+		}
+		return
+	}
+
+	if len(s.types) > 0 && !matchesType(v, s.types) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), jsonType(v))
+		return
+	}
+	if s.constant != nil && !reflect.DeepEqual(v, *s.constant) {
+		fail("must be %s", encodeJSON(*s.constant))
+	}
+	if s.enum != nil && !containsValue(s.enum, v) {
+		values := make([]string, len(s.enum))
+		for i, e := range s.enum {
+			values[i] = encodeJSON(e)
+		}
+		fail("must be one of %s", strings.Join(values, ", "))
+	}
+
+	switch value := v.(type) {
+	case float64:
+		s.validateNumber(value, fail)
+	case string:
+		s.validateString(value, fail)
+	case []interface{}:
+		s.validateArray(value, path, violations, fail)
+	case map[string]interface{}:
+		s.validateObject(value, path, violations, fail)
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, violations)
+	}
+	if len(s.anyOf) > 0 && countMatches(s.anyOf, v) == 0 {
+		fail("must match at least one of the anyOf schemas")
+	}
+	if len(s.oneOf) > 0 {
+		if n := countMatches(s.oneOf, v); n != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", n)
+		}
+	}
+	if s.not != nil && countMatches([]*Schema{s.not}, v) == 1 {
+		fail("must not match the not schema")
+	}
+}
+
+func (s *Schema) validateNumber(n float64, fail func(string, ...interface{})) {
+	if s.minimum != nil && n < *s.minimum {
+		fail("must be >= %v", *s.minimum)
+	}
+	if s.maximum != nil && n > *s.maximum {
+		fail("must be <= %v", *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum {
+		fail("must be > %v", *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum {
+		fail("must be < %v", *s.exclusiveMaximum)
+	}
+	if s.multipleOf != nil {
+		if q := n / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("must be a multiple of %v", *s.multipleOf)
+		}
+	}
+}
+
+func (s *Schema) validateString(str string, fail func(string, ...interface{})) {
+	length := utf8.RuneCountInString(str)
+	if s.minLength != nil && length < *s.minLength {
+		fail("must be at least %d characters long", *s.minLength)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		fail("must be at most %d characters long", *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		fail("must match the pattern %s", s.pattern)
+	}
+}
+
+func (s *Schema) validateArray(items []interface{}, path string, violations *SchemaError, fail func(string, ...interface{})) {
+	if s.minItems != nil && len(items) < *s.minItems {
+		fail("must have at least %d items", *s.minItems)
+	}
+	if s.maxItems != nil && len(items) > *s.maxItems {
+		fail("must have at most %d items", *s.maxItems)
+	}
+	if s.uniqueItems {
+		for i := range items {
+			if containsValue(items[:i], items[i]) {
+				fail("items must be unique, item %d is a duplicate", i)
+				break
+			}
+		}
+	}
+
+	for i, item := range items {
+		itemPath := path + "/" + strconv.Itoa(i)
+		if s.tupleItems != nil {
+			if i < len(s.tupleItems) {
+				s.tupleItems[i].validate(item, itemPath, violations)
+			} else if s.additionalItems != nil {
+				s.additionalItems.validate(item, itemPath, violations)
+			}
+		} else if s.items != nil {
+			s.items.validate(item, itemPath, violations)
+		}
+	}
+}
+
+func (s *Schema) validateObject(obj map[string]interface{}, path string, violations *SchemaError, fail func(string, ...interface{})) {
+	if s.minProperties != nil && len(obj) < *s.minProperties {
+		fail("must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(obj) > *s.maxProperties {
+		fail("must have at most %d properties", *s.maxProperties)
+	}
+
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			*violations = append(*violations, SchemaViolation{Path: path + "/" + escapePointer(name), Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		propPath := path + "/" + escapePointer(name)
+		matched := false
+		if prop, ok := s.properties[name]; ok {
+			prop.validate(obj[name], propPath, violations)
+			matched = true
+		}
+		for pattern, prop := range s.patternProperties {
+			if pattern.MatchString(name) {
+				prop.validate(obj[name], propPath, violations)
+				matched = true
+			}
+		}
+		if !matched && s.additional != nil {
+			s.additional.validate(obj[name], propPath, violations)
+		}
+	}
+}
+
+// schemaCompiler compiles the schemas of a document, sharing the schemas of references
+type schemaCompiler struct {
+	root interface{}
+	refs map[string]*Schema
+}
+
+func (c *schemaCompiler) compile(raw interface{}, s *Schema) error {
+	if b, ok := raw.(bool); ok {
+		s.always = &b
+		return nil
+	}
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return IllegalArgumentError(fmt.Sprintf("schema must be an object or boolean, got %s", jsonType(raw)))
+	}
+	for _, keyword := range unsupportedKeywords {
+		if _, ok := m[keyword]; ok {
+			return IllegalArgumentError(fmt.Sprintf("unsupported keyword %q", keyword))
+		}
+	}
+
+	// as of draft 7, $ref overrides the other keywords of a schema
+	if ref, ok := m["$ref"].(string); ok {
+		target, err := c.resolve(ref)
+		if err != nil {
+			return err
+		}
+		s.allOf = []*Schema{target}
+		return nil
+	}
+
+	var err error
+	sub := func(key string) *Schema {
+		v, ok := m[key]
+		if !ok || err != nil {
+			return nil
+		}
+		compiled := &Schema{}
+		if compileErr := c.compile(v, compiled); compileErr != nil {
+			err = compileErr
+		}
+		return compiled
+	}
+	subs := func(key string) []*Schema {
+		list, ok := m[key].([]interface{})
+		if !ok || err != nil {
+			return nil
+		}
+		compiled := make([]*Schema, len(list))
+		for i, v := range list {
+			compiled[i] = &Schema{}
+			if compileErr := c.compile(v, compiled[i]); compileErr != nil {
+				err = compileErr
+			}
+		}
+		return compiled
+	}
+	number := func(key string) *float64 {
+		if n, ok := m[key].(float64); ok {
+			return &n
+		}
+		return nil
+	}
+	integer := func(key string) *int {
+		if n, ok := m[key].(float64); ok {
+			i := int(n)
+			return &i
+		}
+		return nil
+	}
+
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			if name, ok := v.(string); ok {
+				s.types = append(s.types, name)
+			}
+		}
+	}
+
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		s.properties = map[string]*Schema{}
+		for name, v := range props {
+			s.properties[name] = &Schema{}
+			if err := c.compile(v, s.properties[name]); err != nil {
+				return err
+			}
+		}
+	}
+	if props, ok := m["patternProperties"].(map[string]interface{}); ok {
+		s.patternProperties = map[*regexp.Regexp]*Schema{}
+		for pattern, v := range props {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return IllegalArgumentError(fmt.Sprintf("invalid pattern %q: %s", pattern, err))
+			}
+			s.patternProperties[re] = &Schema{}
+			if err := c.compile(v, s.patternProperties[re]); err != nil {
+				return err
+			}
+		}
+	}
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, v := range required {
+			if name, ok := v.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+
+	s.additional = sub("additionalProperties")
+	if _, ok := m["items"].([]interface{}); ok {
+		s.tupleItems = subs("items")
+		s.additionalItems = sub("additionalItems")
+	} else {
+		s.items = sub("items")
+	}
+
+	if enum, ok := m["enum"].([]interface{}); ok {
+		s.enum = enum
+	}
+	if constant, ok := m["const"]; ok {
+		s.constant = &constant
+	}
+
+	s.minimum, s.maximum = number("minimum"), number("maximum")
+	s.exclusiveMinimum, s.exclusiveMaximum = number("exclusiveMinimum"), number("exclusiveMaximum")
+	// draft 4 declares exclusive bounds as booleans modifying minimum and maximum
+	if exclusive, _ := m["exclusiveMinimum"].(bool); exclusive {
+		s.exclusiveMinimum, s.minimum = s.minimum, nil
+	}
+	if exclusive, _ := m["exclusiveMaximum"].(bool); exclusive {
+		s.exclusiveMaximum, s.maximum = s.maximum, nil
+	}
+	s.multipleOf = number("multipleOf")
+
+	s.minLength, s.maxLength = integer("minLength"), integer("maxLength")
+	s.minItems, s.maxItems = integer("minItems"), integer("maxItems")
+	s.minProperties, s.maxProperties = integer("minProperties"), integer("maxProperties")
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+
+	if pattern, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return IllegalArgumentError(fmt.Sprintf("invalid pattern %q: %s", pattern, err))
+		}
+	}
+
+	s.allOf, s.anyOf, s.oneOf = subs("allOf"), subs("anyOf"), subs("oneOf")
+	s.not = sub("not")
+
+	return err
+}
+
+// resolve returns the schema ref points to within the document
+func (c *schemaCompiler) resolve(ref string) (*Schema, error) {
+	if s, ok := c.refs[ref]; ok {
+		return s, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, IllegalArgumentError(fmt.Sprintf("unsupported $ref %q, only references within the schema are supported", ref))
+	}
+
+	target := c.root
+	pointer := strings.TrimPrefix(ref, "#")
+	if pointer != "" {
+		for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+			token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+			switch v := target.(type) {
+			case map[string]interface{}:
+				target = v[token]
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(v) {
+					return nil, IllegalArgumentError(fmt.Sprintf("unresolvable $ref %q", ref))
+				}
+				target = v[i]
+			default:
+				target = nil
+			}
+			if target == nil {
+				return nil, IllegalArgumentError(fmt.Sprintf("unresolvable $ref %q", ref))
+			}
+		}
+	}
+
+	// registered before compiling, so that recursive references resolve to it
+	s := &Schema{}
+	c.refs[ref] = s
+	if err := c.compile(target, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func countMatches(schemas []*Schema, v interface{}) int {
+	n := 0
+	for _, s := range schemas {
+		var violations SchemaError
+		s.validate(v, "", &violations)
+		if len(violations) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func matchesType(v interface{}, types []string) bool {
+	actual := jsonType(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+func encodeJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// normalizeJSON returns v as the types encoding/json decodes into, e.g. for payloads built as Go structs
+func normalizeJSON(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, bool, float64, string, []interface{}, map[string]interface{}:
+		return v
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var normalized interface{}
+	json.Unmarshal(b, &normalized)
+	return normalized
+}
+
+// ValidatingRouter a Router checking the payloads of requests and responses against JSON Schemas
+type ValidatingRouter struct {
+	Router
+	input  *Schema
+	output *Schema
+}
+
+// NewValidatingRouter returns a ValidatingRouter delegating to router. Either schema may be nil to skip validation.
+func NewValidatingRouter(router Router, input, output *Schema) *ValidatingRouter {
+	return &ValidatingRouter{
+		Router: router,
+		input:  input,
+		output: output,
+	}
+}
+
+// Delegate rejects input with an InputError if its payload does not match the input schema, before any server
+// is used, and fails with a FunctionError if the function returns a payload not matching the output schema
+func (r *ValidatingRouter) Delegate(input *Request) (*Message, error) {
+	if resp := r.validateInput(input); resp != nil {
+		return resp, nil
+	}
+
+	resp, err := r.Router.Delegate(input)
+	if err != nil || r.output == nil || resp.Context == nil || resp.Context.Error != nil {
+		return resp, err
+	}
+	if _, ok := resp.Payload.(*RawPayload); ok {
+		return resp, nil
+	}
+
+	if err := r.output.Validate(resp.Payload); err != nil {
+		resp.Context.Error = &Error{
+			ErrorType: FunctionError,
+			Message:   fmt.Sprintf("Output does not match the schema: %s", err),
+		}
+		resp.Payload = nil
+	}
+	return resp, nil
+}
+
+// DelegateStream rejects input like Delegate does. Streamed output is not validated.
+func (r *ValidatingRouter) DelegateStream(input *Request, chunk func(json.RawMessage) error) (*Message, error) {
+	if resp := r.validateInput(input); resp != nil {
+		return resp, nil
+	}
+	return r.Router.DelegateStream(input, chunk)
+}
+
+func (r *ValidatingRouter) validateInput(input *Request) *Message {
+	if r.input == nil {
+		return nil
+	}
+	if err := r.input.Validate(input.Payload); err != nil {
+		return NewErrorMessage(InputError, fmt.Errorf("Input does not match the schema: %s", err))
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretsContextKey the Request.Context key secrets are injected under
+const SecretsContextKey = "secrets"
+
+// EnvironmentFilter a struct to select the environment variables servers inherit from funky.
+// Patterns are variable names, or prefixes when ending in '*'.
+type EnvironmentFilter struct {
+	// Allow if not empty, only matching variables are inherited
+	Allow []string
+	// Deny matching variables are never inherited
+	Deny []string
+}
+
+// Filter returns the entries of env, formatted as "key=value", that pass the filter
+func (f EnvironmentFilter) Filter(env []string) []string {
+	filtered := []string{}
+	for _, kv := range env {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if len(f.Allow) > 0 && !matchesAny(key, f.Allow) {
+			continue
+		}
+		if matchesAny(key, f.Deny) {
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
+
+func matchesAny(key string, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if key == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Secrets a set of secret values loaded from a mounted directory (one file per key) or a dotenv file
+type Secrets struct {
+	path string
+
+	lock        sync.RWMutex
+	values      map[string]string
+	fingerprint string
+}
+
+// NewSecrets returns Secrets loaded from the directory or dotenv file at path
+func NewSecrets(path string) (*Secrets, error) {
+	s := &Secrets{
+		path: path,
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Values returns a copy of the current secret values
+func (s *Secrets) Values() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	values := make(map[string]string, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	return values
+}
+
+// Environ returns the secrets formatted as "key=value" environment entries
+func (s *Secrets) Environ() []string {
+	values := s.Values()
+	env := make([]string, 0, len(values))
+	for k, v := range values {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Reload reads the secrets from disk again
+func (s *Secrets) Reload() error {
+	fingerprint, err := s.currentFingerprint()
+	if err != nil {
+		return err
+	}
+
+	var values map[string]string
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		values, err = readSecretsDir(s.path)
+	} else {
+		values, err = readDotenv(s.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.values = values
+	s.fingerprint = fingerprint
+	return nil
+}
+
+// Watch reloads the secrets whenever the files they are read from change, checking every interval until stop is closed.
+// changed, if not nil, is called after the secrets were reloaded.
+func (s *Secrets) Watch(interval time.Duration, stop <-chan struct{}, changed func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			fingerprint, err := s.currentFingerprint()
+			if err != nil {
+				continue
+			}
+
+			s.lock.RLock()
+			modified := fingerprint != s.fingerprint
+			s.lock.RUnlock()
+
+			if modified {
+				if err := s.Reload(); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed reloading secrets from %s: %s\n", s.path, err)
+				} else if changed != nil {
+					changed()
+				}
+			}
+		}
+	}
+}
+
+// currentFingerprint summarizes names, sizes and modification times of the secret files.
+// Mounted secrets are swapped through symlinks, so the files are stat'ed through them.
+func (s *Secrets) currentFingerprint() (string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return fmt.Sprintf("%d/%d", info.Size(), info.ModTime().UnixNano()), nil
+	}
+
+	names, err := secretNames(s.path)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(s.path, name))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d/%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// secretNames lists the secret files of dir, skipping hidden entries such as Kubernetes' ..data
+func secretNames(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func readSecretsDir(dir string) (map[string]string, error) {
+	names, err := secretNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		values[name] = strings.TrimRight(string(b), "\r\n")
+	}
+	return values, nil
+}
+
+func readDotenv(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("Invalid line %d in %s", n, path)
+		}
+		values[strings.TrimSpace(kv[0])] = unquote(strings.TrimSpace(kv[1]))
+	}
+	return values, s.Err()
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
@@ -7,10 +7,15 @@ package funky
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
 	"os/exec"
 	"strings"
@@ -18,15 +23,28 @@ import (
 	"time"
 )
 
+// NDJSONContentType the content type of newline delimited JSON, used for streamed responses
+const NDJSONContentType = "application/x-ndjson"
+
+// terminateLogTimeout bounds the wait for the last lines of a terminated server, whose output may be held open by
+// processes it started
+const terminateLogTimeout = time.Second
+
 // Server an interface for managing function servers
 type Server interface {
 	GetPort() uint16
+	GetPID() int
 	Invoke(input *Request) (interface{}, error)
+	InvokeStream(input *Request, chunk func(json.RawMessage) error) error
+	Forward(input *Request, w http.ResponseWriter, r *http.Request) error
 	Stdout() []string
 	Stderr() []string
+	Truncated() int
+	Records() []LogRecord
 	Start() error
 	Shutdown() error
 	Terminate() error
+	WaitReady(deadline time.Time) error
 }
 
 // DefaultServer a struct to hold information about running servers
@@ -36,8 +54,16 @@ type DefaultServer struct {
 	client *http.Client
 
 	lock   sync.RWMutex
-	stdout []string
-	stderr []string
+	limits LogLimits
+	stdout *logBuffer
+	stderr *logBuffer
+	// scanners the goroutines reading stdout and stderr, which must be done before the server is reaped
+	scanners sync.WaitGroup
+
+	sink         LogSink
+	redactor     *Redactor
+	invocationID string
+	secrets      []string
 }
 
 // NewServer returns a new DefaultServer with the given port and command
@@ -52,6 +78,8 @@ func NewServer(port uint16, cmd *exec.Cmd) (*DefaultServer, error) {
 		port:   port,
 		cmd:    cmd,
 		client: &http.Client{},
+		stdout: newLogBuffer(LogLimits{}),
+		stderr: newLogBuffer(LogLimits{}),
 	}, nil
 }
 
@@ -60,30 +88,88 @@ type ServerFactory interface {
 	CreateServer(port uint16) (Server, error)
 }
 
+// ServerOptions optional settings applied to every server created by a DefaultServerFactory
+type ServerOptions struct {
+	// Isolation starts each server in its own Linux namespaces when set
+	Isolation *IsolationConfig
+	// Environment selects the environment variables servers inherit from funky
+	Environment EnvironmentFilter
+	// Secrets are added to the environment of every server when set
+	Secrets *Secrets
+	// LogLimits bounds the logs captured for each invocation
+	LogLimits LogLimits
+	// LogSink receives every line servers write, in addition to the logs returned with invocations
+	LogSink LogSink
+	// Redactor masks secrets in the lines written to LogSink when set
+	Redactor *Redactor
+}
+
 // DefaultServerFactory concrete implementation of ServerFactory.
 type DefaultServerFactory struct {
-	cmd  string
-	args []string
+	cmd     string
+	args    []string
+	options ServerOptions
 }
 
 // NewDefaultServerFactory a DefaultServerFactory constructor; validates the server command.
 func NewDefaultServerFactory(serverCmd string) (ServerFactory, error) {
+	return NewDefaultServerFactoryWithOptions(serverCmd, ServerOptions{})
+}
+
+// NewDefaultServerFactoryWithOptions a DefaultServerFactory constructor accepting ServerOptions; validates the server command.
+func NewDefaultServerFactoryWithOptions(serverCmd string, options ServerOptions) (ServerFactory, error) {
 	cmds := strings.Fields(serverCmd)
 
 	if len(cmds) < 1 {
 		return nil, IllegalArgumentError(serverCmd)
 	}
 
+	if options.Isolation != nil {
+		if err := options.Isolation.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	return &DefaultServerFactory{
-		cmd:  cmds[0],
-		args: cmds[1:],
+		cmd:     cmds[0],
+		args:    cmds[1:],
+		options: options,
 	}, nil
 }
 
 // CreateServer creates a new server by initiating a Command with the given port and preconfigured server command
 func (f *DefaultServerFactory) CreateServer(port uint16) (Server, error) {
 	cmd := exec.Command(f.cmd, f.args...)
-	return NewServer(port, cmd)
+	socket := ""
+	if f.options.Isolation != nil {
+		var err error
+		cmd, socket, err = isolateCommand(port, f.cmd, f.args, f.options.Isolation)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	server, err := NewServer(port, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Env = f.options.Environment.Filter(os.Environ())
+	if f.options.Secrets != nil {
+		cmd.Env = append(cmd.Env, f.options.Secrets.Environ()...)
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("PORT=%d", port))
+
+	if socket != "" {
+		server.client.Transport = unixTransport(socket)
+	}
+
+	server.limits = f.options.LogLimits
+	server.sink = f.options.LogSink
+	server.redactor = f.options.Redactor
+	server.resetStreams()
+
+	return server, nil
 }
 
 // GetPort returns the port this server is running on
@@ -91,35 +177,101 @@ func (s *DefaultServer) GetPort() uint16 {
 	return s.port
 }
 
-// Invoke calls the server with the given input to invoke a Dispatch function
+// GetPID returns the process ID of the server, 0 if it has not started
+func (s *DefaultServer) GetPID() int {
+	if s.cmd.Process == nil {
+		return 0
+	}
+	return s.cmd.Process.Pid
+}
+
+// Invoke calls the server with the given input to invoke a Dispatch function.
+// Responses with a content type other than JSON are returned as a *RawPayload.
 func (s *DefaultServer) Invoke(input *Request) (interface{}, error) {
-	p, err := json.Marshal(input)
+	defer s.endInvocation()
 
-	timeout := time.Duration(0)
-	if deadline, ok := input.Context["deadline"]; ok {
-		if dl, ok := deadline.(string); ok {
-			t, err := time.Parse(time.RFC3339, dl)
-			if err != nil {
-				return nil, BadRequestError(fmt.Sprintf("Unable to parse deadline: %s", err))
+	resp, err := s.post(input, "application/json, */*;q=0.8")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, s.readError(err)
+	}
+
+	return decodeResult(resp.Header.Get("Content-Type"), body)
+}
+
+// InvokeStream calls the server with the given input, passing every JSON value of an NDJSON response to chunk
+// as it arrives. A plain JSON response is passed as a single chunk.
+func (s *DefaultServer) InvokeStream(input *Request, chunk func(json.RawMessage) error) error {
+	defer s.endInvocation()
+
+	resp, err := s.post(input, NDJSONContentType+", application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), NDJSONContentType) {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return s.readError(err)
+		}
+		if !json.Valid(body) {
+			return InvalidResponsePayloadError("response is not valid JSON")
+		}
+		return chunk(json.RawMessage(body))
+	}
+
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if !json.Valid(line) {
+				return InvalidResponsePayloadError(fmt.Sprintf("invalid NDJSON line: %s", line))
 			}
-			timeout = time.Until(t)
+			if chunkErr := chunk(json.RawMessage(line)); chunkErr != nil {
+				return chunkErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return s.readError(err)
 		}
 	}
+}
 
-	if timeout < 0 {
-		return nil, TimeoutError("Did not invoke, already exceeded timeout")
+// post sends input to the server within the deadline of the request, failing on connection or function errors
+func (s *DefaultServer) post(input *Request, accept string) (*http.Response, error) {
+	p, err := json.Marshal(input)
+	if err != nil {
+		return nil, BadRequestError(err.Error())
+	}
+
+	timeout, err := invocationTimeout(input)
+	if err != nil {
+		return nil, err
 	}
 
 	s.client.Timeout = timeout
 
 	s.resetStreams()
+	s.beginInvocation(input)
 
 	url := fmt.Sprintf("http://127.0.0.1:%d", s.GetPort())
-	resp, err := s.client.Post(url, "application/json", bytes.NewBuffer(p))
-	if err == nil {
-		defer resp.Body.Close()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(p))
+	if err != nil {
+		return nil, UnknownSystemError(err.Error())
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
 
+	resp, err := s.client.Do(req)
 	if err != nil {
 		if isTimeout(err) {
 			return nil, TimeoutError("Function execution exceeded the timeout")
@@ -131,6 +283,7 @@ func (s *DefaultServer) Invoke(input *Request) (interface{}, error) {
 	}
 
 	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
 		var e Error
 		json.NewDecoder(resp.Body).Decode(&e)
 		return nil, FunctionServerError{
@@ -138,58 +291,181 @@ func (s *DefaultServer) Invoke(input *Request) (interface{}, error) {
 		}
 	}
 
-	var result interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, InvalidResponsePayloadError(err.Error())
+	return resp, nil
+}
+
+// invocationTimeout returns the time left until the deadline of input, zero if it has none
+func invocationTimeout(input *Request) (time.Duration, error) {
+	timeout := time.Duration(0)
+	if deadline, ok := input.Context["deadline"]; ok {
+		if dl, ok := deadline.(string); ok {
+			t, err := time.Parse(time.RFC3339, dl)
+			if err != nil {
+				return 0, BadRequestError(fmt.Sprintf("Unable to parse deadline: %s", err))
+			}
+			timeout = time.Until(t)
+		}
+	}
+
+	if timeout < 0 {
+		return 0, TimeoutError("Did not invoke, already exceeded timeout")
+	}
+
+	return timeout, nil
+}
+
+// Forward proxies r to the server as-is and streams the response to w. input carries the invocation ID
+// and deadline of the request. Nothing is written to w if the server could not be reached.
+func (s *DefaultServer) Forward(input *Request, w http.ResponseWriter, r *http.Request) (err error) {
+	timeout, err := invocationTimeout(input)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	s.resetStreams()
+	s.beginInvocation(input)
+	defer s.endInvocation()
+
+	target := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", s.GetPort())}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+		},
+		Transport:     s.client.Transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, proxyErr error) {
+			err = s.forwardError(ctx, target, proxyErr)
+		},
+	}
+
+	// the proxy aborts the handler if copying the response fails once it started
+	defer func() {
+		if p := recover(); p != nil {
+			if p != http.ErrAbortHandler {
+				panic(p)
+			}
+			err = s.forwardError(ctx, target, ctx.Err())
+		}
+	}()
+
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+	return err
+}
+
+func (s *DefaultServer) forwardError(ctx context.Context, target *url.URL, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return TimeoutError("Function execution exceeded the timeout")
+	}
+	if err == nil {
+		return UnknownSystemError("response was interrupted")
 	}
+	if isConnectionRefused(err) {
+		return ConnectionRefusedError(target.String())
+	}
+	return UnknownSystemError(err.Error())
+}
 
-	return result, nil
+// readError maps an error reading a response. Unlike an UnknownSystemError, which is only returned
+// if the function server could not be sent the request, an InvocationError means the function may have run.
+func (s *DefaultServer) readError(err error) error {
+	if isTimeout(err) {
+		return TimeoutError("Function execution exceeded the timeout")
+	}
+	return InvocationError(err.Error())
 }
 
-// Stdout returns the Buffer containing stdout
+// Stdout returns the lines captured from stdout
 func (s *DefaultServer) Stdout() []string {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	return s.stdout
+	return s.stdout.lines()
 }
 
-// Stderr returns the Buffer containing stderr
+// Stderr returns the lines captured from stderr
 func (s *DefaultServer) Stderr() []string {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	return s.stderr
+	return s.stderr.lines()
+}
+
+// Truncated returns the number of captured lines dropped or shortened to stay within the log limits
+func (s *DefaultServer) Truncated() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.stdout.truncated() + s.stderr.truncated()
+}
+
+// Records returns the captured lines of both streams as LogRecords in time order
+func (s *DefaultServer) Records() []LogRecord {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return mergeRecords(buildRecords(StdoutStream, s.stdout.captured()), buildRecords(StderrStream, s.stderr.captured()))
 }
 
 func (s *DefaultServer) resetStreams() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	s.stdout = nil
-	s.stderr = nil
+	s.stdout = newLogBuffer(s.limits)
+	s.stderr = newLogBuffer(s.limits)
+}
+
+// beginInvocation tags the lines forwarded to the sink with the invocation ID of input until endInvocation
+func (s *DefaultServer) beginInvocation(input *Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.invocationID, _ = input.Context[InvocationIDContextKey].(string)
+	s.secrets = contextSecrets(input.Context)
+}
+
+func (s *DefaultServer) endInvocation() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.invocationID = ""
+	s.secrets = nil
 }
 
-func scanStream(r io.Reader, ss *[]string, l sync.Locker) {
-	s := bufio.NewScanner(r)
-	s.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, io.EOF
+// scanStream captures the lines read from r into the buffer of stream and forwards them to the sink
+func (s *DefaultServer) scanStream(r io.Reader, stream string) {
+	readLines(r, s.limits.MaxLineLength, func(line string, shortened bool) {
+		s.lock.Lock()
+		buffer := s.stdout
+		if stream == StderrStream {
+			buffer = s.stderr
 		}
+		buffer.append(line, shortened)
+		invocationID, secrets := s.invocationID, s.secrets
+		s.lock.Unlock()
 
-		for i := 0; i < len(data); i++ {
-			if data[i] == '\n' {
-				return i + 1, data[:i], nil
-			}
+		if s.sink == nil {
+			return
 		}
 
-		return len(data), data, nil
+		if s.redactor != nil {
+			line = s.redactor.Redact(line, secrets)
+		}
+		err := s.sink.Write(&LogLine{
+			Time:         time.Now().UTC(),
+			InvocationID: invocationID,
+			Port:         s.port,
+			Stream:       stream,
+			Text:         line,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed writing log line to sink: %s\n", err)
+		}
 	})
-	for s.Scan() {
-		func() {
-			l.Lock()
-			defer l.Unlock()
-			*ss = append(*ss, s.Text())
-		}()
-	}
 }
 
 // Start starts the server
@@ -198,8 +474,15 @@ func (s *DefaultServer) Start() error {
 	stdout, _ := s.cmd.StdoutPipe()
 	stderr, _ := s.cmd.StderrPipe()
 
-	go scanStream(stdout, &s.stdout, &s.lock)
-	go scanStream(stderr, &s.stderr, &s.lock)
+	s.scanners.Add(2)
+	go func() {
+		defer s.scanners.Done()
+		s.scanStream(stdout, StdoutStream)
+	}()
+	go func() {
+		defer s.scanners.Done()
+		s.scanStream(stderr, StderrStream)
+	}()
 
 	return s.cmd.Start()
 }
@@ -216,7 +499,48 @@ func (s *DefaultServer) Shutdown() error {
 
 // Terminate kills the server without waiting for a graceful shutdown.
 func (s *DefaultServer) Terminate() error {
-	return s.cmd.Process.Kill()
+	if err := s.cmd.Process.Kill(); err != nil {
+		return err
+	}
+
+	// reaping the process closes its pipes, so its last lines are read first
+	scanned := make(chan struct{})
+	go func() {
+		s.scanners.Wait()
+		close(scanned)
+	}()
+	select {
+	case <-scanned:
+	case <-time.After(terminateLogTimeout):
+	}
+
+	// reap the process, which reports that it was killed
+	s.cmd.Wait()
+	return nil
+}
+
+// WaitReady waits until the server accepts connections, failing with a TimeoutError at deadline
+func (s *DefaultServer) WaitReady(deadline time.Time) error {
+	addr := fmt.Sprintf("127.0.0.1:%d", s.GetPort())
+	var dialer net.Dialer
+	dial := dialer.DialContext
+	if t, ok := s.client.Transport.(*http.Transport); ok && t.DialContext != nil {
+		dial = t.DialContext
+	}
+
+	for {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		conn, err := dial(ctx, "tcp", addr)
+		cancel()
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().Add(serverPollInterval).After(deadline) {
+			return TimeoutError("Function server did not start listening before the deadline")
+		}
+		time.Sleep(serverPollInterval)
+	}
 }
 
 func isTimeout(err error) bool {
@@ -228,5 +552,6 @@ func isTimeout(err error) bool {
 }
 
 func isConnectionRefused(err error) bool {
-	return strings.Contains(err.Error(), "connection refused")
+	// a missing Unix socket means an isolated server has not started listening yet
+	return strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "no such file or directory")
 }
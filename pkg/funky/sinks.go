@@ -0,0 +1,196 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Names of the streams captured from function servers
+const (
+	StdoutStream = "stdout"
+	StderrStream = "stderr"
+)
+
+// LogLine a line of function output forwarded to a LogSink. Lines emitted outside an invocation have no InvocationID.
+type LogLine struct {
+	Time         time.Time `json:"time"`
+	InvocationID string    `json:"invocationId,omitempty"`
+	Port         uint16    `json:"port"`
+	Stream       string    `json:"stream"`
+	Text         string    `json:"text"`
+}
+
+// LogSink an interface for durable destinations of function logs
+type LogSink interface {
+	Write(line *LogLine) error
+	Close() error
+}
+
+// MultiSink a LogSink writing to several sinks
+type MultiSink []LogSink
+
+// Write writes line to every sink, returning the last error
+func (m MultiSink) Write(line *LogLine) error {
+	var err error
+	for _, sink := range m {
+		if e := sink.Write(line); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close closes every sink, returning the last error
+func (m MultiSink) Close() error {
+	var err error
+	for _, sink := range m {
+		if e := sink.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// WriterSink a LogSink writing lines as JSON objects, one per line, to an io.Writer
+type WriterSink struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewWriterSink returns a WriterSink writing to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{
+		w: w,
+	}
+}
+
+// NewStdoutSink returns a WriterSink writing to funky's stdout, e.g. for kubectl logs
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+// Write writes line as JSON
+func (s *WriterSink) Write(line *LogLine) error {
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the underlying writer unless it is stdout or stderr
+func (s *WriterSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok && s.w != os.Stdout && s.w != os.Stderr {
+		return c.Close()
+	}
+	return nil
+}
+
+// RotatingFileSink a LogSink writing JSON lines to a file, rotated when it grows past a size
+type RotatingFileSink struct {
+	path     string
+	maxBytes int64
+	backups  int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFileSink returns a RotatingFileSink writing to path. When the file exceeds maxBytes it is renamed
+// to path.1, path.1 to path.2 and so on, keeping at most backups old files.
+func NewRotatingFileSink(path string, maxBytes int64, backups int) (*RotatingFileSink, error) {
+	if maxBytes < 1 {
+		return nil, IllegalArgumentError("maxBytes")
+	}
+
+	s := &RotatingFileSink{
+		path:     path,
+		maxBytes: maxBytes,
+		backups:  backups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write writes line as JSON, rotating the file first if needed
+func (s *RotatingFileSink) Write(line *LogLine) error {
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.size > 0 && s.size+int64(len(b)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the current file
+func (s *RotatingFileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.file.Close()
+}
+
+func (s *RotatingFileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *RotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	if s.backups < 1 {
+		os.Remove(s.path)
+	} else {
+		for i := s.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	}
+
+	return s.open()
+}
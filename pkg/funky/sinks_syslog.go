@@ -0,0 +1,46 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package funky
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// SyslogSink a LogSink writing to syslog, stderr lines at error and stdout lines at info severity
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink returns a SyslogSink connected to the syslog daemon at addr over network, e.g. "unixgram" and
+// "/dev/log" or "udp" and "host:514". An empty network connects to the local daemon.
+func NewSyslogSink(network, addr string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_USER, "funky")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyslogSink{
+		writer: w,
+	}, nil
+}
+
+// Write sends line to syslog
+func (s *SyslogSink) Write(line *LogLine) error {
+	msg := fmt.Sprintf("invocation=%s port=%d stream=%s %s", line.InvocationID, line.Port, line.Stream, line.Text)
+	if line.Stream == StderrStream {
+		return s.writer.Err(msg)
+	}
+	return s.writer.Info(msg)
+}
+
+// Close closes the connection to syslog
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
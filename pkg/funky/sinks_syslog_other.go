@@ -0,0 +1,14 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+
+//go:build windows || plan9
+// +build windows plan9
+
+package funky
+
+// NewSyslogSink syslog is not available on this platform
+func NewSyslogSink(network, addr string) (LogSink, error) {
+	return nil, IllegalArgumentError("syslog is not supported on this platform")
+}
@@ -0,0 +1,39 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"net/http"
+)
+
+// StatusCode returns the HTTP status code of an invocation failing with e, http.StatusOK if e is nil.
+// Errors reported by the function itself are answered with functionErrorStatus.
+func StatusCode(e *Error, functionErrorStatus int) int {
+	if e == nil {
+		return http.StatusOK
+	}
+
+	switch e.cause.(type) {
+	case TimeoutError:
+		return http.StatusGatewayTimeout
+	case QueueFullError:
+		return http.StatusTooManyRequests
+	case ConnectionRefusedError, CircuitOpenError:
+		return http.StatusServiceUnavailable
+	case BadRequestError:
+		return http.StatusBadRequest
+	case UnknownFunctionError:
+		return http.StatusNotFound
+	}
+
+	switch e.ErrorType {
+	case InputError:
+		return http.StatusBadRequest
+	case FunctionError:
+		return functionErrorStatus
+	default:
+		return http.StatusBadGateway
+	}
+}
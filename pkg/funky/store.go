@@ -0,0 +1,269 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Statuses of asynchronous invocations
+const (
+	InvocationPending   = "pending"
+	InvocationCompleted = "completed"
+	InvocationLost      = "lost"
+)
+
+// Invocation a struct to hold the status and result of an asynchronous invocation
+type Invocation struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Result   *Message   `json:"result,omitempty"`
+}
+
+// journalRecord a line of the invocation journal
+type journalRecord struct {
+	Invocation *Invocation `json:"invocation"`
+}
+
+// InvocationStore an in-memory store of asynchronous invocations, bounded in size. Completed invocations
+// expire after a TTL. An optional journal file lets the store survive restarts.
+type InvocationStore struct {
+	ttl        time.Duration
+	maxEntries int
+
+	lock        sync.Mutex
+	entries     map[string]*Invocation
+	order       []string
+	journalPath string
+	journal     *os.File
+	journalSize int
+}
+
+// NewInvocationStore returns an InvocationStore holding at most maxEntries invocations, keeping completed ones for ttl.
+// If journalPath is not empty, invocations are journaled to that file and restored from it; invocations that were
+// still pending when funky stopped are restored as lost.
+func NewInvocationStore(ttl time.Duration, maxEntries int, journalPath string) (*InvocationStore, error) {
+	if maxEntries < 1 {
+		return nil, IllegalArgumentError("maxEntries")
+	}
+
+	s := &InvocationStore{
+		ttl:         ttl,
+		maxEntries:  maxEntries,
+		entries:     map[string]*Invocation{},
+		journalPath: journalPath,
+	}
+
+	if journalPath != "" {
+		if err := s.restore(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Create adds a pending invocation with the given ID. Fails with QueueFullError when the store is full of pending invocations.
+func (s *InvocationStore) Create(id string) (*Invocation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.entries[id]; ok {
+		return nil, IllegalArgumentError("duplicate invocation ID " + id)
+	}
+
+	s.purge()
+	if len(s.entries) >= s.maxEntries && !s.evictOldestCompleted() {
+		return nil, QueueFullError("invocation store is full")
+	}
+
+	inv := &Invocation{
+		ID:      id,
+		Status:  InvocationPending,
+		Created: time.Now().UTC(),
+	}
+	s.entries[id] = inv
+	s.order = append(s.order, id)
+	s.write(inv)
+
+	found := *inv
+	return &found, nil
+}
+
+// Complete records the result of the invocation with the given ID
+func (s *InvocationStore) Complete(id string, result *Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	inv, ok := s.entries[id]
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	inv.Status = InvocationCompleted
+	inv.Finished = &now
+	inv.Result = result
+	s.write(inv)
+}
+
+// Get returns a copy of the invocation with the given ID
+func (s *InvocationStore) Get(id string) (*Invocation, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.purge()
+	inv, ok := s.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	found := *inv
+	return &found, true
+}
+
+// Close closes the journal
+func (s *InvocationStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Close()
+}
+
+// purge removes expired invocations
+func (s *InvocationStore) purge() {
+	now := time.Now()
+	kept := s.order[:0]
+	for _, id := range s.order {
+		inv := s.entries[id]
+		if inv.Finished != nil && s.ttl > 0 && now.Sub(*inv.Finished) > s.ttl {
+			delete(s.entries, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
+
+// evictOldestCompleted removes the oldest finished invocation, returns false if all invocations are pending
+func (s *InvocationStore) evictOldestCompleted() bool {
+	for i, id := range s.order {
+		if s.entries[id].Finished != nil {
+			delete(s.entries, id)
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// write appends inv to the journal, compacting it once it holds many superseded records
+func (s *InvocationStore) write(inv *Invocation) {
+	if s.journal == nil {
+		return
+	}
+
+	if s.journalSize > 4*s.maxEntries {
+		if err := s.compact(); err == nil {
+			return
+		}
+	}
+
+	b, err := json.Marshal(journalRecord{Invocation: inv})
+	if err != nil {
+		return
+	}
+	s.journal.Write(append(b, '\n'))
+	s.journalSize++
+}
+
+// restore loads the journal, marks invocations pending before the restart as lost and compacts the journal
+func (s *InvocationStore) restore() error {
+	f, err := os.Open(s.journalPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var record journalRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Invocation == nil {
+				// a partially written last line, e.g. after a crash
+				continue
+			}
+			id := record.Invocation.ID
+			if _, ok := s.entries[id]; !ok {
+				s.order = append(s.order, id)
+			}
+			s.entries[id] = record.Invocation
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, inv := range s.entries {
+		if inv.Status == InvocationPending {
+			inv.Status = InvocationLost
+			inv.Finished = &now
+			inv.Result = NewErrorMessage(SystemError, errors.New("funky restarted before the invocation completed"))
+		}
+	}
+
+	s.purge()
+	for len(s.order) > s.maxEntries {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	return s.compact()
+}
+
+// compact rewrites the journal with only the current state of each invocation
+func (s *InvocationStore) compact() error {
+	tmp := s.journalPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, id := range s.order {
+		b, err := json.Marshal(journalRecord{Invocation: s.entries[id]})
+		if err != nil {
+			continue
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(tmp, s.journalPath); err != nil {
+		f.Close()
+		return err
+	}
+
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	s.journal = f
+	s.journalSize = len(s.order)
+	return nil
+}
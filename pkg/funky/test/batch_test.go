@@ -0,0 +1,74 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBatchResultsAndErrors(t *testing.T) {
+	isFailing := func(r *funky.Request) bool { return r.Payload == "fail" }
+
+	router := new(mocks.Router)
+	router.On("Delegate", mock.MatchedBy(isFailing)).Return(nil, errors.New("boom"))
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(func(r *funky.Request) *funky.Message {
+		return &funky.Message{Payload: r.Payload}
+	}, nil)
+
+	inputs := []*funky.Request{{Payload: "a"}, {Payload: "fail"}, nil, {Payload: "d"}}
+	results := make([]*funky.Message, len(inputs))
+	calls := 0
+	funky.Batch(router, inputs, 2, func(i int, msg *funky.Message) {
+		calls++
+		results[i] = msg
+	})
+
+	if calls != 4 {
+		t.Fatalf("Expected 4 results, got %d", calls)
+	}
+	if results[0].Payload != "a" || results[3].Payload != "d" {
+		t.Errorf("Results not in input order: %+v, %+v", results[0], results[3])
+	}
+	if e := results[1].Context.Error; e == nil || e.ErrorType != funky.SystemError {
+		t.Errorf("Expected a SystemError for the failing item, got %+v", results[1])
+	}
+	if e := results[2].Context.Error; e == nil || e.ErrorType != funky.InputError {
+		t.Errorf("Expected an InputError for the null item, got %+v", results[2])
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	router := new(mocks.Router)
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(func(r *funky.Request) *funky.Message {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return &funky.Message{}
+	}, nil)
+
+	inputs := make([]*funky.Request, 20)
+	for i := range inputs {
+		inputs[i] = &funky.Request{}
+	}
+	funky.Batch(router, inputs, 3, func(int, *funky.Message) {})
+
+	if max := atomic.LoadInt32(&maxInFlight); max > 3 {
+		t.Errorf("Expected at most 3 concurrent invocations, got %d", max)
+	}
+}
@@ -0,0 +1,103 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+// newFlakyRouter returns a router failing the invocations whose payload is "fail"
+func newFlakyRouter() *mocks.Router {
+	router := new(mocks.Router)
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(func(r *funky.Request) *funky.Message {
+		if r.Payload == "fail" {
+			return funky.NewErrorMessage(funky.FunctionError, errors.New("dependency down"))
+		}
+		return &funky.Message{Context: &funky.Context{}, Payload: r.Payload}
+	}, nil)
+	return router
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	router := newFlakyRouter()
+	breaker := funky.NewCircuitBreaker(router, funky.BreakerOptions{
+		FailureRate:  0.5,
+		Window:       4,
+		MinRequests:  4,
+		OpenDuration: 50 * time.Millisecond,
+		Probes:       2,
+	})
+
+	for _, payload := range []string{"ok", "fail", "ok", "fail"} {
+		if _, err := breaker.Delegate(&funky.Request{Payload: payload}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if breaker.State() != funky.CircuitOpen {
+		t.Fatalf("Expected an open circuit, got %s", breaker.State())
+	}
+
+	if _, err := breaker.Delegate(&funky.Request{Payload: "ok"}); err == nil {
+		t.Errorf("Expected a CircuitOpenError")
+	} else if _, ok := err.(funky.CircuitOpenError); !ok {
+		t.Errorf("Expected a CircuitOpenError, got %v", err)
+	}
+	router.AssertNumberOfCalls(t, "Delegate", 4)
+
+	time.Sleep(60 * time.Millisecond)
+	if breaker.State() != funky.CircuitHalfOpen {
+		t.Fatalf("Expected a half-open circuit, got %s", breaker.State())
+	}
+	breaker.Delegate(&funky.Request{Payload: "ok"})
+	breaker.Delegate(&funky.Request{Payload: "ok"})
+	if breaker.State() != funky.CircuitClosed {
+		t.Errorf("Expected the circuit to close after the probes succeeded, got %s", breaker.State())
+	}
+}
+
+func TestCircuitBreakerProbeFailureReopens(t *testing.T) {
+	breaker := funky.NewCircuitBreaker(newFlakyRouter(), funky.BreakerOptions{
+		FailureRate:  1,
+		Window:       2,
+		MinRequests:  2,
+		OpenDuration: 20 * time.Millisecond,
+	})
+
+	breaker.Delegate(&funky.Request{Payload: "fail"})
+	breaker.Delegate(&funky.Request{Payload: "ok"})
+	breaker.Delegate(&funky.Request{Payload: "fail"})
+	if breaker.State() != funky.CircuitClosed {
+		t.Fatalf("Expected a closed circuit below the failure rate, got %s", breaker.State())
+	}
+	breaker.Delegate(&funky.Request{Payload: "fail"})
+	if breaker.State() != funky.CircuitOpen {
+		t.Fatalf("Expected an open circuit, got %s", breaker.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	breaker.Delegate(&funky.Request{Payload: "fail"})
+	if breaker.State() != funky.CircuitOpen {
+		t.Errorf("Expected the circuit to open again after a failed probe, got %s", breaker.State())
+	}
+}
+
+func TestCircuitBreakerIgnoresSystemErrors(t *testing.T) {
+	router := new(mocks.Router)
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(nil, funky.QueueFullError("full"))
+	breaker := funky.NewCircuitBreaker(router, funky.BreakerOptions{FailureRate: 0.1, Window: 2, MinRequests: 1})
+
+	for i := 0; i < 3; i++ {
+		breaker.Delegate(&funky.Request{})
+	}
+	if breaker.State() != funky.CircuitClosed {
+		t.Errorf("Expected a closed circuit, got %s", breaker.State())
+	}
+}
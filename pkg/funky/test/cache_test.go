@@ -0,0 +1,170 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func newCountingRouter(calls *int) *mocks.Router {
+	router := new(mocks.Router)
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(func(r *funky.Request) *funky.Message {
+		*calls++
+		if r.Payload == "fail" {
+			return funky.NewErrorMessage(funky.FunctionError, funky.InvocationError("failed"))
+		}
+		return &funky.Message{Context: &funky.Context{}, Payload: r.Payload}
+	}, nil)
+	return router
+}
+
+func TestCachingRouterHits(t *testing.T) {
+	calls := 0
+	metrics := funky.NewMetrics()
+	router := funky.NewCachingRouter(newCountingRouter(&calls), funky.CacheOptions{
+		TTL:         time.Minute,
+		ContextKeys: []string{"tenant"},
+		Metrics:     metrics,
+	})
+
+	request := func(payload interface{}, tenant string) *funky.Message {
+		resp, _ := router.Delegate(&funky.Request{
+			Context: map[string]interface{}{"tenant": tenant, "deadline": time.Now().String()},
+			Payload: payload,
+		})
+		return resp
+	}
+
+	if resp := request(map[string]interface{}{"a": 1.0, "b": 2.0}, "x"); resp.Context.Cached {
+		t.Errorf("Expected first invocation not to be cached")
+	}
+	if resp := request(map[string]interface{}{"b": 2.0, "a": 1.0}, "x"); !resp.Context.Cached {
+		t.Errorf("Expected second invocation to be served from the cache")
+	}
+	request(map[string]interface{}{"a": 1.0, "b": 2.0}, "y")
+	request("fail", "x")
+	request("fail", "x")
+
+	if calls != 4 {
+		t.Errorf("Expected 4 delegated invocations, got %d", calls)
+	}
+
+	var out strings.Builder
+	metrics.WriteTo(&out)
+	for _, line := range []string{"funky_cache_hits_total 1\n", "funky_cache_misses_total 4\n", "funky_cache_entries 2\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestCachingRouterHitHasOwnContext(t *testing.T) {
+	inner := new(mocks.Router)
+	inner.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(func(r *funky.Request) *funky.Message {
+		return &funky.Message{
+			Context: &funky.Context{
+				Logs:         &funky.Logs{Stdout: []string{"computing for first"}},
+				InvocationID: r.Context[funky.InvocationIDContextKey].(string),
+				Attempts:     2,
+			},
+			Payload: "result",
+		}
+	}, nil)
+	router := funky.NewCachingRouter(inner, funky.CacheOptions{TTL: time.Minute})
+
+	request := func(id string) *funky.Message {
+		resp, _ := router.Delegate(&funky.Request{
+			Context: map[string]interface{}{funky.InvocationIDContextKey: id},
+			Payload: "same",
+		})
+		return resp
+	}
+
+	request("first")
+	resp := request("second")
+	if !resp.Context.Cached || resp.Payload != "result" {
+		t.Fatalf("Expected the cached result, got %+v", resp)
+	}
+	if resp.Context.InvocationID != "second" {
+		t.Errorf("Expected the invocation ID of the hit, got %q", resp.Context.InvocationID)
+	}
+	if resp.Context.Logs == nil || len(resp.Context.Logs.Stdout) != 0 || resp.Context.Attempts != 0 {
+		t.Errorf("Expected none of the first invocation's logs or attempts, got %+v", resp.Context)
+	}
+}
+
+func TestCachingRouterCacheControl(t *testing.T) {
+	calls := 0
+	router := funky.NewCachingRouter(newCountingRouter(&calls), funky.CacheOptions{TTL: time.Minute})
+
+	request := func(cacheControl string) *funky.Message {
+		resp, _ := router.Delegate(&funky.Request{
+			Context: map[string]interface{}{funky.CacheControlContextKey: cacheControl},
+			Payload: "p",
+		})
+		return resp
+	}
+
+	request("no-store")
+	if resp := request(""); resp.Context.Cached {
+		t.Errorf("Expected no-store result not to be cached")
+	}
+	if resp := request("no-cache"); resp.Context.Cached {
+		t.Errorf("Expected no-cache to skip the cache")
+	}
+	if resp := request(""); !resp.Context.Cached {
+		t.Errorf("Expected cached result")
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 delegated invocations, got %d", calls)
+	}
+}
+
+func TestCachingRouterExpiryAndEviction(t *testing.T) {
+	calls := 0
+	router := funky.NewCachingRouter(newCountingRouter(&calls), funky.CacheOptions{TTL: 50 * time.Millisecond, MaxBytes: 150})
+
+	for _, payload := range []string{"a", "b", "c", "a"} {
+		router.Delegate(&funky.Request{Payload: payload})
+	}
+	if calls != 4 {
+		t.Errorf("Expected a to be evicted, got %d invocations", calls)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if resp, _ := router.Delegate(&funky.Request{Payload: "a"}); resp.Context.Cached {
+		t.Errorf("Expected expired result not to be served")
+	}
+}
+
+func TestMetricsFormat(t *testing.T) {
+	metrics := funky.NewMetrics()
+	metrics.Counter("requests_total", "Requests.", "code", "200").Add(2)
+	metrics.Counter("requests_total", "Requests.", "code", "500").Inc()
+	metrics.Gauge("temperature", "Temperature.", func() float64 { return 1.5 })
+
+	var nilMetrics *funky.Metrics
+	nilMetrics.Counter("ignored", "Ignored.").Inc()
+
+	var out strings.Builder
+	metrics.WriteTo(&out)
+	expected := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{code="200"} 2
+requests_total{code="500"} 1
+# HELP temperature Temperature.
+# TYPE temperature gauge
+temperature 1.5
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected metrics output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,159 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+// newGatedRouter returns a router signalling started on every invocation, which returns its payload once release
+// is closed
+func newGatedRouter(calls *int32, started chan struct{}, release chan struct{}) *mocks.Router {
+	router := new(mocks.Router)
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(func(r *funky.Request) *funky.Message {
+		atomic.AddInt32(calls, 1)
+		started <- struct{}{}
+		<-release
+		return &funky.Message{Context: &funky.Context{InvocationID: "leader"}, Payload: r.Payload}
+	}, nil)
+	return router
+}
+
+func TestCoalescingRouter(t *testing.T) {
+	var calls int32
+	started, release := make(chan struct{}, 10), make(chan struct{})
+	metrics := funky.NewMetrics()
+	coalescing := funky.NewCoalescingRouter(newGatedRouter(&calls, started, release), nil, metrics)
+
+	var wg sync.WaitGroup
+	delegate := func(requests []*funky.Request) []*funky.Message {
+		responses := make([]*funky.Message, len(requests))
+		for i, r := range requests {
+			wg.Add(1)
+			go func(i int, r *funky.Request) {
+				defer wg.Done()
+				responses[i], _ = coalescing.Delegate(r)
+			}(i, r)
+		}
+		return responses
+	}
+
+	keyed := func(payload string) *funky.Request {
+		return &funky.Request{Payload: payload, Context: map[string]interface{}{funky.IdempotencyKeyContextKey: "k"}}
+	}
+
+	leaders := delegate([]*funky.Request{{Payload: "same"}, {Payload: "other"}, keyed("other")})
+	receive(t, started, 3)
+	waiters := delegate([]*funky.Request{{Payload: "same"}, {Payload: "same"}, keyed("other")})
+	waitForMetric(t, metrics, "funky_coalesced_waiting 3")
+
+	// an idempotency key in use with another payload is rejected rather than given the other payload's result
+	rejected := make(chan *funky.Message, 1)
+	go func() {
+		resp, _ := coalescing.Delegate(keyed("different"))
+		rejected <- resp
+	}()
+	select {
+	case resp := <-rejected:
+		if resp.Context.Error == nil || funky.StatusCode(resp.Context.Error, 500) != http.StatusBadRequest {
+			t.Errorf("Expected an idempotency key reused with another payload to be rejected, got %+v", resp.Context)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected an idempotency key reused with another payload to be rejected rather than wait")
+	}
+
+	close(release)
+	wg.Wait()
+
+	if calls != 3 {
+		t.Errorf("Expected 3 delegated invocations, got %d", calls)
+	}
+	for i, resp := range append(leaders, waiters...) {
+		if resp.Context.InvocationID != "leader" || resp.Context.Coalesced != (i >= len(leaders)) {
+			t.Errorf("Unexpected response %d: %+v", i, resp.Context)
+		}
+	}
+	if v := metrics.Counter("funky_coalesced_total", "").Value(); v != 3 {
+		t.Errorf("Expected funky_coalesced_total 3, got %v", v)
+	}
+	waitForMetric(t, metrics, "funky_coalesced_waiting 0")
+
+	// later invocations are delegated again
+	if resp, _ := coalescing.Delegate(&funky.Request{Payload: "same"}); resp.Context.Coalesced || calls != 4 {
+		t.Errorf("Expected a new invocation once the first completed")
+	}
+}
+
+func TestCoalescingRouterWaiterDeadline(t *testing.T) {
+	var calls int32
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	coalescing := funky.NewCoalescingRouter(newGatedRouter(&calls, started, release), nil, nil)
+	defer close(release)
+
+	go coalescing.Delegate(&funky.Request{Payload: "same"})
+	receive(t, started, 1)
+
+	errs := make(chan error, 1)
+	go func() {
+		deadline := time.Now().Add(20 * time.Millisecond).Format(time.RFC3339Nano)
+		_, err := coalescing.Delegate(&funky.Request{Payload: "same", Context: map[string]interface{}{"deadline": deadline}})
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if _, ok := err.(funky.TimeoutError); !ok {
+			t.Errorf("Expected the waiter to time out at its deadline, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the waiter to give up at its deadline while the invocation is in flight")
+	}
+}
+
+func TestCoalescingRouterKeyPerFunction(t *testing.T) {
+	var calls int32
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	router := new(mocks.Router)
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(func(r *funky.Request) *funky.Message {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-release
+		return &funky.Message{Context: &funky.Context{}, Payload: r.Context[funky.FunctionContextKey]}
+	}, nil)
+
+	coalescing := funky.NewCoalescingRouter(router, nil, nil)
+
+	var wg sync.WaitGroup
+	functions := []string{"first", "second"}
+	responses := make([]*funky.Message, len(functions))
+	for i, function := range functions {
+		wg.Add(1)
+		go func(i int, function string) {
+			defer wg.Done()
+			responses[i], _ = coalescing.Delegate(&funky.Request{Context: map[string]interface{}{
+				funky.FunctionContextKey:       function,
+				funky.IdempotencyKeyContextKey: "k",
+			}})
+		}(i, function)
+	}
+	// both are delegated while the other is in flight, which they would not be if they shared the key
+	receive(t, started, 2)
+	close(release)
+	wg.Wait()
+
+	for i, resp := range responses {
+		if resp.Payload != functions[i] || resp.Context.Coalesced {
+			t.Errorf("Expected the result of function %s, got %v coalesced %v", functions[i], resp.Payload, resp.Context.Coalesced)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+// newMockServer returns a server on port whose invocations return result and err
+func newMockServer(port uint16, result interface{}, err error) *mocks.Server {
+	server := new(mocks.Server)
+	server.On("Invoke", mock.AnythingOfType("*funky.Request")).Return(result, err)
+	return expectLifecycle(server, port)
+}
+
+// newBlockingServer returns a server on port whose invocations return result once release is closed
+func newBlockingServer(port uint16, result interface{}, release chan struct{}) *mocks.Server {
+	server := new(mocks.Server)
+	server.On("Invoke", mock.AnythingOfType("*funky.Request")).Run(func(mock.Arguments) { <-release }).Return(result, nil)
+	return expectLifecycle(server, port)
+}
+
+// expectLifecycle lets server on port start, listen, log nothing and stop without errors. Its PID is its port.
+func expectLifecycle(server *mocks.Server, port uint16) *mocks.Server {
+	server.On("GetPID").Return(int(port))
+	server.On("GetPort").Return(port)
+	server.On("Shutdown").Return(nil)
+	server.On("Start").Return(nil)
+	server.On("Stderr").Return([]string{})
+	server.On("Stdout").Return([]string{})
+	server.On("Terminate").Return(nil)
+	server.On("Truncated").Return(0)
+	server.On("WaitReady", mock.AnythingOfType("time.Time")).Return(nil)
+	return server
+}
+
+// waitForMetric waits until the metrics hold line, failing the test if they do not within a second
+func waitForMetric(t *testing.T, metrics *funky.Metrics, line string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		var out strings.Builder
+		metrics.WriteTo(&out)
+		if strings.Contains(out.String(), line+"\n") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected metrics to contain %q, got:\n%s", line, out.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// receive waits for n values on ch, failing the test if they do not arrive within a second
+func receive(t *testing.T, ch <-chan struct{}, n int) {
+	timeout := time.After(time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-ch:
+		case <-timeout:
+			t.Fatalf("Received %d of %d expected signals", i, n)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+func TestHTTPContextApply(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/users/1?verbose=true", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Request-Id", "abc")
+	r.Header.Add("Accept", "text/html")
+	r.Header.Add("Accept", "application/json")
+
+	input := &funky.Request{Context: map[string]interface{}{funky.HTTPContextKey: "spoofed"}}
+	funky.NewHTTPContext([]string{"accept", "X-Request-*"}).Apply(input, r)
+
+	info, ok := input.Context[funky.HTTPContextKey].(*funky.HTTPRequestInfo)
+	if !ok {
+		t.Fatalf("Expected HTTPRequestInfo, got %v", input.Context[funky.HTTPContextKey])
+	}
+	if info.Method != "PUT" || info.Path != "/users/1" || info.Query.Get("verbose") != "true" {
+		t.Errorf("Unexpected request info %+v", info)
+	}
+	if len(info.Headers) != 2 || info.Headers["X-Request-Id"] != "abc" || info.Headers["Accept"] != "text/html, application/json" {
+		t.Errorf("Unexpected headers %v", info.Headers)
+	}
+}
+
+func TestParseHTTPResult(t *testing.T) {
+	tests := []struct {
+		payload interface{}
+		ok      bool
+	}{
+		{map[string]interface{}{"statusCode": 201.0, "body": "created"}, true},
+		{map[string]interface{}{"statusCode": 200.0, "headers": map[string]interface{}{"X-A": "b"}}, true},
+		{map[string]interface{}{"statusCode": 200.0, "body": "aGk=", "isBase64Encoded": true}, true},
+		{map[string]interface{}{"statusCode": 200.0, "body": "not base64!", "isBase64Encoded": true}, false},
+		{map[string]interface{}{"statusCode": 200.0, "name": "Jon"}, false},
+		{map[string]interface{}{"statusCode": 42.0}, false},
+		{map[string]interface{}{"statusCode": 101.0}, false},
+		{map[string]interface{}{"statusCode": 600.0}, false},
+		{map[string]interface{}{"statusCode": "200"}, false},
+		{map[string]interface{}{"statusCode": 200.0, "headers": map[string]interface{}{"X-A": 1.0}}, false},
+		{"statusCode", false},
+	}
+
+	for _, test := range tests {
+		if _, ok := funky.ParseHTTPResult(test.payload); ok != test.ok {
+			t.Errorf("ParseHTTPResult(%v) = %v, expected %v", test.payload, ok, test.ok)
+		}
+	}
+}
+
+func TestHTTPResultWrite(t *testing.T) {
+	result, _ := funky.ParseHTTPResult(map[string]interface{}{
+		"statusCode": 404.0,
+		"headers": map[string]interface{}{
+			"X-Reason":          "gone",
+			"connection":        "close",
+			"Transfer-Encoding": "chunked",
+			"Content-Length":    "1000",
+			"Upgrade":           "websocket",
+		},
+		"body": map[string]interface{}{"error": "not found"},
+	})
+
+	w := httptest.NewRecorder()
+	if err := result.Write(w); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if w.Code != 404 || w.Header().Get("X-Reason") != "gone" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected response %d %v", w.Code, w.Header())
+	}
+	for _, name := range []string{"Connection", "Transfer-Encoding", "Content-Length", "Upgrade"} {
+		if v := w.Header().Get(name); v != "" {
+			t.Errorf("Expected hop-by-hop header %s to be dropped, got %q", name, v)
+		}
+	}
+	if w.Body.String() != `{"error":"not found"}` {
+		t.Errorf("Unexpected body %q", w.Body.String())
+	}
+
+	result, _ = funky.ParseHTTPResult(map[string]interface{}{"statusCode": 200.0, "body": "aGk=", "isBase64Encoded": true})
+	w = httptest.NewRecorder()
+	result.Write(w)
+	if w.Body.String() != "hi" {
+		t.Errorf("Expected decoded body, got %q", w.Body.String())
+	}
+}
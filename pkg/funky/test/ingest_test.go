@@ -0,0 +1,118 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+func TestIngestJSON(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"context": {"deadline": "x"}, "payload": {"name": "Jon"}}`))
+
+	req, _, err := funky.NewIngester("", 0).Decode(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.Context["deadline"] != "x" || req.Payload.(map[string]interface{})["name"] != "Jon" {
+		t.Errorf("Unexpected request %+v", req)
+	}
+}
+
+func TestIngestRawBody(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("a,b\n1,2\n"))
+	r.Header.Set("Content-Type", "text/csv")
+
+	req, _, err := funky.NewIngester("", 0).Decode(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.Context[funky.ContentTypeContextKey] != "text/csv" || req.Context[funky.EncodingContextKey] != funky.Base64Encoding {
+		t.Errorf("Unexpected context %+v", req.Context)
+	}
+	if req.Payload != "YSxiCjEsMgo=" {
+		t.Errorf("Expected base64 payload, got %v", req.Payload)
+	}
+}
+
+func TestIngestMaxBytes(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("0123456789"))
+	r.Header.Set("Content-Type", "application/octet-stream")
+
+	if _, _, err := funky.NewIngester("", 4).Decode(httptest.NewRecorder(), r); err == nil {
+		t.Errorf("Expected an error for a body over the limit")
+	}
+}
+
+func TestIngestForm(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("name=Jon&tag=a&tag=b"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	req, _, err := funky.NewIngester("", 0).Decode(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	form := req.Payload.(*funky.FormPayload)
+	if form.Fields.Get("name") != "Jon" || len(form.Fields["tag"]) != 2 {
+		t.Errorf("Unexpected fields %+v", form.Fields)
+	}
+}
+
+func TestIngestMultipart(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "funky-test")
+	defer os.RemoveAll(dir)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "Jon")
+	fw, _ := mw.CreateFormFile("image", "../../photo.png")
+	fw.Write([]byte("not really a png"))
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	req, cleanup, err := funky.NewIngester(dir, 0).Decode(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	form := req.Payload.(*funky.FormPayload)
+	if form.Fields["name"][0] != "Jon" || len(form.Files["image"]) != 1 {
+		t.Fatalf("Unexpected form %+v", form)
+	}
+	file := form.Files["image"][0]
+	if !strings.HasPrefix(file.Path, dir) || file.Filename != "photo.png" || file.Size != 16 {
+		t.Errorf("Unexpected file %+v", file)
+	}
+	if b, _ := ioutil.ReadFile(file.Path); string(b) != "not really a png" {
+		t.Errorf("Unexpected file content %q", b)
+	}
+
+	cleanup()
+	if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected spooled file to be removed")
+	}
+}
+
+func TestIngestJSONSentAsForm(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"payload": "hello"}`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	req, _, err := funky.NewIngester("", 0).Decode(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if req.Payload != "hello" {
+		t.Errorf("Expected JSON request, got %+v", req)
+	}
+}
@@ -0,0 +1,43 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"testing"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+func TestIsolationConfigValidate(t *testing.T) {
+	tests := []struct {
+		config    funky.IsolationConfig
+		socketDir string
+		ok        bool
+	}{
+		{funky.IsolationConfig{}, "", true},
+		{funky.IsolationConfig{SocketDir: "/tmp"}, "/tmp", true},
+		{funky.IsolationConfig{Network: true}, funky.DefaultSocketDir, true},
+		{funky.IsolationConfig{Network: true, SocketDir: "/run/funky/"}, "/run/funky", true},
+		{funky.IsolationConfig{Network: true, SocketDir: "/tmpfs/funky"}, "/tmpfs/funky", true},
+		{funky.IsolationConfig{Network: true, SocketDir: "/tmp"}, "", false},
+		{funky.IsolationConfig{Network: true, SocketDir: "/tmp/funky"}, "", false},
+		{funky.IsolationConfig{Network: true, SocketDir: "/run/../tmp/funky"}, "", false},
+	}
+
+	for _, test := range tests {
+		config := test.config
+		_, err := funky.NewDefaultServerFactoryWithOptions("echo", funky.ServerOptions{Isolation: &config})
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("Validating %+v returned %v, expected ok %v", test.config, err, test.ok)
+			continue
+		}
+		if _, illegal := err.(funky.IllegalArgumentError); err != nil && !illegal {
+			t.Errorf("Expected IllegalArgumentError validating %+v, got %v", test.config, err)
+		}
+		if test.ok && config.SocketDir != test.socketDir {
+			t.Errorf("Expected SocketDir %s validating %+v, got %s", test.socketDir, test.config, config.SocketDir)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOnDemandStartsServer(t *testing.T) {
+	server := newMockServer(funky.FirstPort, "ok", nil)
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Run(func(mock.Arguments) {
+		time.Sleep(30 * time.Millisecond)
+	}).Return(server, nil)
+
+	router, err := funky.NewRouterWithOptions(2, serverFactory, funky.RouterOptions{OnDemand: true})
+	if err != nil {
+		t.Fatalf("Failed creating router: %v", err)
+	}
+	defer router.Shutdown()
+	serverFactory.AssertNotCalled(t, "CreateServer", mock.Anything)
+
+	resp, _ := router.Delegate(&funky.Request{})
+	if resp.Payload != "ok" || resp.Context.ColdStartMs < 30 {
+		t.Errorf("Expected a cold start of at least 30ms, got %+v", resp.Context)
+	}
+
+	resp, _ = router.Delegate(&funky.Request{})
+	if resp.Context.ColdStartMs != 0 {
+		t.Errorf("Expected the idle server to be reused, got %+v", resp.Context)
+	}
+	serverFactory.AssertNumberOfCalls(t, "CreateServer", 1)
+}
+
+func TestOnDemandStopsIdleServers(t *testing.T) {
+	first := newMockServer(funky.FirstPort, "ok", nil)
+	second := newMockServer(funky.FirstPort, "ok", nil)
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(first, nil).Once()
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(second, nil).Once()
+
+	router, _ := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{OnDemand: true, IdleTimeout: 40 * time.Millisecond})
+	defer router.Shutdown()
+
+	router.Delegate(&funky.Request{})
+	time.Sleep(100 * time.Millisecond)
+	first.AssertCalled(t, "Terminate")
+
+	router.Delegate(&funky.Request{})
+	second.AssertNumberOfCalls(t, "Invoke", 1)
+}
+
+func TestOnDemandStartFailure(t *testing.T) {
+	server := new(mocks.Server)
+	server.On("Start").Return(nil)
+	server.On("WaitReady", mock.AnythingOfType("time.Time")).Return(funky.TimeoutError("not listening"))
+	server.On("Terminate").Return(nil)
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(server, nil)
+
+	router, _ := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{OnDemand: true})
+	defer router.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		if _, err := router.Delegate(&funky.Request{}); err == nil {
+			t.Errorf("Expected the invocation to fail when the server does not start")
+		}
+	}
+	server.AssertNumberOfCalls(t, "Terminate", 2)
+}
+
+func TestOnDemandTimeoutFailedRestart(t *testing.T) {
+	timedOut := newMockServer(funky.FirstPort, nil, funky.TimeoutError("timed out"))
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(timedOut, nil).Once()
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(nil, funky.IllegalArgumentError("port")).Once()
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(newMockServer(funky.FirstPort, "ok", nil), nil).Once()
+
+	router, _ := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{OnDemand: true})
+	defer router.Shutdown()
+
+	if resp, _ := router.Delegate(&funky.Request{}); resp.Context.Error == nil {
+		t.Fatal("Expected the invocation to time out")
+	}
+
+	done := make(chan *funky.Message)
+	go func() {
+		resp, _ := router.Delegate(&funky.Request{})
+		done <- resp
+	}()
+	select {
+	case resp := <-done:
+		if resp.Payload != "ok" {
+			t.Errorf("Expected a new server to be started on demand, got %+v", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a server to be started once the timed out one could not be replaced, got %+v", router.Pools())
+	}
+}
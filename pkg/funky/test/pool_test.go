@@ -0,0 +1,207 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+)
+
+func newPoolRouter(t *testing.T, options funky.RouterOptions, servers ...*mocks.Server) (*funky.DefaultRouter, *mocks.ServerFactory) {
+	serverFactory := new(mocks.ServerFactory)
+	for i, server := range servers {
+		serverFactory.On("CreateServer", funky.FirstPort+uint16(i)).Return(server, nil).Once()
+	}
+
+	router, err := funky.NewRouterWithOptions(len(servers), serverFactory, options)
+	if err != nil {
+		t.Fatalf("Failed creating router: %v", err)
+	}
+	return router, serverFactory
+}
+
+func TestPools(t *testing.T) {
+	release := make(chan struct{})
+	router, _ := newPoolRouter(t, funky.RouterOptions{MaxServers: 3},
+		newMockServer(funky.FirstPort, "idle", nil), newBlockingServer(funky.FirstPort+1, "busy", release))
+
+	done := make(chan struct{})
+	go func() {
+		router.Delegate(&funky.Request{Context: map[string]interface{}{funky.InvocationIDContextKey: "inv"}})
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	pools := router.Pools()
+	if len(pools) != 1 || pools[0].Size != 2 || pools[0].MaxSize != 3 || len(pools[0].Servers) != 2 {
+		t.Fatalf("Expected a pool of 2 servers out of 3, got %+v", pools)
+	}
+	idle, busy := pools[0].Servers[0], pools[0].Servers[1]
+	if idle.Port != funky.FirstPort || idle.PID != int(funky.FirstPort) || idle.State != funky.ServerIdle {
+		t.Errorf("Expected the first server to be idle, got %+v", idle)
+	}
+	if busy.State != funky.ServerBusy || busy.InvocationID != "inv" || busy.Invocations != 1 {
+		t.Errorf("Expected the second server to run the invocation, got %+v", busy)
+	}
+
+	close(release)
+	<-done
+	if busy = router.Pools()[0].Servers[1]; busy.State != funky.ServerIdle || busy.InvocationID != "" {
+		t.Errorf("Expected the second server to be idle once the invocation completed, got %+v", busy)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	router, _ := newPoolRouter(t, funky.RouterOptions{}, newMockServer(funky.FirstPort, "result", nil))
+
+	router.Pause()
+	done := make(chan *funky.Message)
+	go func() {
+		resp, _ := router.Delegate(&funky.Request{})
+		done <- resp
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Expected invocations to wait while the router is paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if pool := router.Pools()[0]; !pool.Paused || pool.Pending != 1 {
+		t.Errorf("Expected a paused pool with a pending invocation, got %+v", pool)
+	}
+
+	router.Resume()
+	if resp := <-done; resp.Payload != "result" {
+		t.Errorf("Expected the invocation to complete once resumed, got %v", resp.Payload)
+	}
+}
+
+func TestResize(t *testing.T) {
+	first, second := newMockServer(funky.FirstPort, "first", nil), newMockServer(funky.FirstPort+1, "second", nil)
+	router, serverFactory := newPoolRouter(t, funky.RouterOptions{MaxServers: 2}, first)
+	serverFactory.On("CreateServer", funky.FirstPort+1).Return(second, nil).Once()
+
+	if err := router.Resize(2); err != nil {
+		t.Fatalf("Failed growing the pool: %v", err)
+	}
+	if pool := router.Pools()[0]; pool.Size != 2 || len(pool.Servers) != 2 {
+		t.Errorf("Expected a pool of 2 servers, got %+v", pool)
+	}
+	second.AssertCalled(t, "Start")
+
+	if err := router.Resize(1); err != nil {
+		t.Fatalf("Failed shrinking the pool: %v", err)
+	}
+	if pool := router.Pools()[0]; pool.Size != 1 || len(pool.Servers) != 1 {
+		t.Errorf("Expected a pool of 1 server, got %+v", pool)
+	}
+
+	if _, ok := router.Resize(3).(funky.IllegalArgumentError); !ok {
+		t.Error("Expected resizing beyond MaxServers to fail")
+	}
+	if _, ok := router.Resize(0).(funky.IllegalArgumentError); !ok {
+		t.Error("Expected resizing to no servers to fail")
+	}
+}
+
+func TestDrainIdleServer(t *testing.T) {
+	first, second := newMockServer(funky.FirstPort, "first", nil), newMockServer(funky.FirstPort+1, "second", nil)
+	router, _ := newPoolRouter(t, funky.RouterOptions{}, first, second)
+
+	if err := router.DrainServer(funky.FirstPort + 1); err != nil {
+		t.Fatalf("Failed draining server: %v", err)
+	}
+	second.AssertCalled(t, "Terminate")
+	if pool := router.Pools()[0]; pool.Size != 1 || len(pool.Servers) != 1 {
+		t.Errorf("Expected the pool to shrink to 1 server, got %+v", pool)
+	}
+
+	if _, ok := router.DrainServer(funky.FirstPort).(funky.IllegalArgumentError); !ok {
+		t.Error("Expected the last server not to be drained")
+	}
+	first.AssertNotCalled(t, "Terminate")
+}
+
+func TestDrainBusyServer(t *testing.T) {
+	release := make(chan struct{})
+	other, busy := newMockServer(funky.FirstPort, "other", nil), newBlockingServer(funky.FirstPort+1, "busy", release)
+	router, _ := newPoolRouter(t, funky.RouterOptions{}, other, busy)
+
+	invoked := make(chan struct{})
+	go func() {
+		router.Delegate(&funky.Request{})
+		close(invoked)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	drained := make(chan error)
+	go func() {
+		drained <- router.DrainServer(funky.FirstPort + 1)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if state := router.Pools()[0].Servers[1].State; state != funky.ServerDraining {
+		t.Errorf("Expected the busy server to be draining, got %s", state)
+	}
+	busy.AssertNotCalled(t, "Terminate")
+
+	close(release)
+	<-invoked
+	if err := <-drained; err != nil {
+		t.Fatalf("Failed draining server: %v", err)
+	}
+	busy.AssertCalled(t, "Terminate")
+
+	if resp, _ := router.Delegate(&funky.Request{}); resp.Payload != "other" {
+		t.Errorf("Expected invocations to go to the remaining server, got %v", resp.Payload)
+	}
+}
+
+func TestRestartServer(t *testing.T) {
+	old, replaced := newMockServer(funky.FirstPort, "old", nil), newMockServer(funky.FirstPort, "new", nil)
+	router, serverFactory := newPoolRouter(t, funky.RouterOptions{}, old)
+	serverFactory.On("CreateServer", funky.FirstPort).Return(replaced, nil).Once()
+
+	if err := router.RestartServer(funky.FirstPort); err != nil {
+		t.Fatalf("Failed restarting server: %v", err)
+	}
+	old.AssertCalled(t, "Terminate")
+	if restarts := router.Pools()[0].Servers[0].Restarts; restarts != 1 {
+		t.Errorf("Expected 1 restart, got %d", restarts)
+	}
+	if resp, _ := router.Delegate(&funky.Request{}); resp.Payload != "new" {
+		t.Errorf("Expected the new server to be invoked, got %v", resp.Payload)
+	}
+
+	if err := router.RestartServer(1234); err != funky.UnknownServerError(1234) {
+		t.Errorf("Expected UnknownServerError, got %v", err)
+	}
+}
+
+func TestRegistryRoutesServersByPort(t *testing.T) {
+	first, second := new(mocks.Router), new(mocks.Router)
+	first.On("Pools").Return([]funky.PoolStatus{{Servers: []funky.ServerStatus{{Port: 9000}}}})
+	second.On("Pools").Return([]funky.PoolStatus{{Servers: []funky.ServerStatus{{Port: 9001}}}})
+	second.On("DrainServer", uint16(9001)).Return(nil)
+
+	registry := funky.NewRegistry(map[string]funky.Router{"first": first, "second": second}, "")
+
+	if err := registry.DrainServer(9001); err != nil {
+		t.Errorf("Failed draining server: %v", err)
+	}
+	second.AssertCalled(t, "DrainServer", uint16(9001))
+	if err := registry.DrainServer(9002); err != funky.UnknownServerError(9002) {
+		t.Errorf("Expected UnknownServerError, got %v", err)
+	}
+
+	if pools := registry.Pools(); len(pools) != 2 || pools[0].Function != "first" || pools[1].Function != "second" {
+		t.Errorf("Expected the pools of both functions, got %+v", pools)
+	}
+	if _, ok := registry.Resize(2).(funky.UnknownFunctionError); !ok {
+		t.Error("Expected resizing without a default function to fail")
+	}
+}
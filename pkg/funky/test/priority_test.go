@@ -0,0 +1,161 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+// gatedServers returns servers recording the payloads they are invoked with and signalling started, each
+// invocation waiting for gate
+func gatedServers(n int, gate chan struct{}, started chan struct{}) ([]*mocks.Server, func() []interface{}) {
+	var lock sync.Mutex
+	invoked := []interface{}{}
+
+	servers := []*mocks.Server{}
+	for i := 0; i < n; i++ {
+		server := new(mocks.Server)
+		server.On("Invoke", mock.AnythingOfType("*funky.Request")).Run(func(args mock.Arguments) {
+			lock.Lock()
+			invoked = append(invoked, args.Get(0).(*funky.Request).Payload)
+			lock.Unlock()
+			started <- struct{}{}
+			<-gate
+		}).Return("done", nil)
+		servers = append(servers, expectLifecycle(server, funky.FirstPort+uint16(i)))
+	}
+
+	return servers, func() []interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]interface{}{}, invoked...)
+	}
+}
+
+func invokeWithPriority(router funky.Router, payload string, priority interface{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		router.Delegate(&funky.Request{
+			Context: map[string]interface{}{funky.PriorityContextKey: priority},
+			Payload: payload,
+		})
+	}()
+}
+
+func TestPriorityOrder(t *testing.T) {
+	gate, started := make(chan struct{}), make(chan struct{}, 10)
+	servers, invoked := gatedServers(1, gate, started)
+	metrics := funky.NewMetrics()
+	router, _ := newPoolRouter(t, funky.RouterOptions{Metrics: metrics}, servers...)
+
+	var wg sync.WaitGroup
+	invokeWithPriority(router, "first", 0, &wg)
+	receive(t, started, 1)
+	invokeWithPriority(router, "low", 0, &wg)
+	invokeWithPriority(router, "high", "10", &wg)
+	invokeWithPriority(router, "higher", 20.0, &wg)
+	waitForMetric(t, metrics, "funky_invocations_queued 3")
+	close(gate)
+	wg.Wait()
+
+	expected := []interface{}{"first", "higher", "high", "low"}
+	if order := invoked(); !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected invocations in order of priority %v, got %v", expected, order)
+	}
+}
+
+func TestPriorityReserved(t *testing.T) {
+	gate, started := make(chan struct{}), make(chan struct{}, 10)
+	servers, invoked := gatedServers(2, gate, started)
+	metrics := funky.NewMetrics()
+	router, _ := newPoolRouter(t, funky.RouterOptions{
+		Metrics:  metrics,
+		Priority: funky.PriorityOptions{Reserved: map[int]int{10: 1}},
+	}, servers...)
+
+	var wg sync.WaitGroup
+	invokeWithPriority(router, "low", 0, &wg)
+	receive(t, started, 1)
+	invokeWithPriority(router, "waiting", 0, &wg)
+	waitForMetric(t, metrics, "funky_invocations_queued 1")
+	invokeWithPriority(router, "high", 10, &wg)
+	receive(t, started, 1)
+
+	expected := []interface{}{"low", "high"}
+	if running := invoked(); !reflect.DeepEqual(running, expected) {
+		t.Errorf("Expected the reserved server to be kept for the high priority %v, got %v", expected, running)
+	}
+
+	close(gate)
+	wg.Wait()
+	if n := len(invoked()); n != 3 {
+		t.Errorf("Expected the waiting invocation to run once a server is free, got %d invocations", n)
+	}
+
+	if _, err := funky.NewRouterWithOptions(1, new(mocks.ServerFactory), funky.RouterOptions{
+		Priority: funky.PriorityOptions{Reserved: map[int]int{10: 1}},
+	}); err == nil {
+		t.Error("Expected reserving all servers to fail")
+	}
+}
+
+func TestPriorityReservedPoolSize(t *testing.T) {
+	servers := []*mocks.Server{}
+	for i := 0; i < 3; i++ {
+		servers = append(servers, newMockServer(funky.FirstPort+uint16(i), "done", nil))
+	}
+	router, _ := newPoolRouter(t, funky.RouterOptions{
+		MaxServers: 4,
+		Priority:   funky.PriorityOptions{Reserved: map[int]int{10: 2}},
+	}, servers...)
+
+	if err := router.Resize(2); err == nil {
+		t.Error("Expected resizing the pool to its reserved servers to fail")
+	}
+	if err := router.DrainServer(funky.FirstPort); err == nil {
+		t.Error("Expected draining the pool to its reserved servers to fail")
+	}
+	if size := router.Pools()[0].Size; size != 3 {
+		t.Errorf("Expected the pool to keep its 3 servers, got %d", size)
+	}
+	if resp, err := router.Delegate(&funky.Request{}); err != nil || resp.Payload != "done" {
+		t.Errorf("Expected an invocation of the lowest priority to get the unreserved server, got %+v %v", resp, err)
+	}
+}
+
+func TestPriorityMaxWait(t *testing.T) {
+	gate, started := make(chan struct{}), make(chan struct{}, 10)
+	servers, invoked := gatedServers(1, gate, started)
+	metrics := funky.NewMetrics()
+	router, _ := newPoolRouter(t, funky.RouterOptions{
+		Metrics:  metrics,
+		Priority: funky.PriorityOptions{MaxWait: 50 * time.Millisecond},
+	}, servers...)
+
+	var wg sync.WaitGroup
+	invokeWithPriority(router, "first", 0, &wg)
+	receive(t, started, 1)
+	invokeWithPriority(router, "starving", 0, &wg)
+	waitForMetric(t, metrics, "funky_invocations_queued 1")
+	// the invocation has to wait for longer than MaxWait to starve
+	time.Sleep(60 * time.Millisecond)
+	invokeWithPriority(router, "high", 10, &wg)
+	waitForMetric(t, metrics, "funky_invocations_queued 2")
+	close(gate)
+	wg.Wait()
+
+	expected := []interface{}{"first", "starving", "high"}
+	if order := invoked(); !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected the invocation waiting for longer than MaxWait to go first %v, got %v", expected, order)
+	}
+}
@@ -0,0 +1,78 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+const recordsScript = `
+echo '{"level":"warn","msg":"disk low","free":12}'
+echo 'INFO starting'
+echo 'Traceback (most recent call last):' >&2
+echo '  File "main.py", line 1, in <module>' >&2
+echo '    handle()' >&2
+echo 'ValueError: bad input' >&2
+echo 'plain stderr' >&2
+`
+
+func TestServerRecords(t *testing.T) {
+	f, err := ioutil.TempFile("", "records.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(recordsScript)
+	f.Close()
+
+	factory, _ := funky.NewDefaultServerFactory("sh " + f.Name())
+	server, _ := factory.CreateServer(9090)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer server.Shutdown()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && len(server.Stdout())+len(server.Stderr()) < 7 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	byStream := map[string][]funky.LogRecord{}
+	for _, record := range server.Records() {
+		byStream[record.Stream] = append(byStream[record.Stream], record)
+	}
+
+	stdout := byStream[funky.StdoutStream]
+	if len(stdout) != 2 {
+		t.Fatalf("Expected 2 stdout records, got %+v", stdout)
+	}
+	if stdout[0].Level != funky.WarnLevel || stdout[0].Message != "disk low" || stdout[0].Fields["free"] != float64(12) {
+		t.Errorf("JSON line not parsed: %+v", stdout[0])
+	}
+	if stdout[1].Level != funky.InfoLevel || stdout[1].Message != "INFO starting" {
+		t.Errorf("Unexpected record: %+v", stdout[1])
+	}
+
+	stderr := byStream[funky.StderrStream]
+	if len(stderr) != 2 {
+		t.Fatalf("Expected 2 stderr records, got %+v", stderr)
+	}
+	traceback := "Traceback (most recent call last):\n  File \"main.py\", line 1, in <module>\n    handle()\nValueError: bad input"
+	if stderr[0].Level != funky.ErrorLevel || stderr[0].Message != traceback {
+		t.Errorf("Traceback not grouped: %+v", stderr[0])
+	}
+	if stderr[1].Message != "plain stderr" {
+		t.Errorf("Unexpected record: %+v", stderr[1])
+	}
+
+	if len(server.Stderr()) != 5 {
+		t.Errorf("Legacy stderr lines should be kept, got %v", server.Stderr())
+	}
+}
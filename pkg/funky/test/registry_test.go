@@ -0,0 +1,98 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func newNamedRouter(name string) *mocks.Router {
+	router := new(mocks.Router)
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(&funky.Message{Context: &funky.Context{}, Payload: name}, nil)
+	return router
+}
+
+func TestRegistryRoutesByFunction(t *testing.T) {
+	registry := funky.NewRegistry(map[string]funky.Router{
+		"default": newNamedRouter("default"),
+		"resize":  newNamedRouter("resize"),
+	}, "default")
+
+	tests := []struct {
+		context  map[string]interface{}
+		expected string
+	}{
+		{nil, "default"},
+		{map[string]interface{}{funky.FunctionContextKey: "resize"}, "resize"},
+		{map[string]interface{}{funky.FunctionContextKey: "default"}, "default"},
+	}
+	for _, test := range tests {
+		resp, err := registry.Delegate(&funky.Request{Context: test.context})
+		if err != nil || resp.Payload != test.expected {
+			t.Errorf("Expected %s for %v, got %v %v", test.expected, test.context, resp.Payload, err)
+		}
+	}
+
+	resp, _ := registry.Delegate(&funky.Request{Context: map[string]interface{}{funky.FunctionContextKey: "missing"}})
+	if resp.Context.Error == nil || resp.Context.Error.ErrorType != funky.InputError {
+		t.Fatalf("Expected an InputError for an unknown function, got %+v", resp.Context)
+	}
+	if status := funky.StatusCode(resp.Context.Error, http.StatusInternalServerError); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown function, got %d", status)
+	}
+}
+
+func TestRegistryWithoutDefault(t *testing.T) {
+	registry := funky.NewRegistry(map[string]funky.Router{"resize": newNamedRouter("resize")}, "")
+
+	resp, _ := registry.Delegate(&funky.Request{})
+	if resp.Context.Error == nil {
+		t.Errorf("Expected an error for a request naming no function")
+	}
+}
+
+func TestRouterFirstPortAndDefaultTimeout(t *testing.T) {
+	server := new(mocks.Server)
+	server.On("Start").Return(nil)
+	server.On("Invoke", mock.MatchedBy(func(r *funky.Request) bool {
+		deadline, err := time.Parse(time.RFC3339, r.Context["deadline"].(string))
+		return err == nil && time.Until(deadline) > 4*time.Second && time.Until(deadline) <= 5*time.Second
+	})).Return("ok", nil)
+	server.On("Stdout").Return([]string{})
+	server.On("Stderr").Return([]string{})
+	server.On("Truncated").Return(0)
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(9100)).Return(server, nil)
+
+	router, err := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{FirstPort: 9100, DefaultTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed creating router: %v", err)
+	}
+	if resp, _ := router.Delegate(&funky.Request{}); resp.Payload != "ok" {
+		t.Errorf("Expected the invocation to get the default deadline, got %+v", resp.Context.Error)
+	}
+}
+
+func TestMetricsWithLabels(t *testing.T) {
+	metrics := funky.NewMetrics()
+	metrics.With("function", "a").Counter("calls_total", "Calls.").Inc()
+	metrics.With("function", "b").With("zone", "x").Counter("calls_total", "Calls.").Add(2)
+
+	var b strings.Builder
+	metrics.With("function", "a").WriteTo(&b)
+	expected := "# HELP calls_total Calls.\n# TYPE calls_total counter\n" +
+		"calls_total{function=\"a\"} 1\ncalls_total{function=\"b\",zone=\"x\"} 2\n"
+	if b.String() != expected {
+		t.Errorf("Unexpected metrics:\n%s", b.String())
+	}
+}
@@ -0,0 +1,115 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReloadReplacesServers(t *testing.T) {
+	old := []*mocks.Server{newMockServer(funky.FirstPort, "old", nil), newMockServer(funky.FirstPort+1, "old", nil)}
+	replaced := []*mocks.Server{newMockServer(funky.FirstPort, "new", nil), newMockServer(funky.FirstPort+1, "new", nil)}
+
+	serverFactory := new(mocks.ServerFactory)
+	for i := range old {
+		port := funky.FirstPort + uint16(i)
+		serverFactory.On("CreateServer", port).Return(old[i], nil).Once()
+		serverFactory.On("CreateServer", port).Return(replaced[i], nil).Once()
+	}
+
+	router, err := funky.NewRouterWithOptions(2, serverFactory, funky.RouterOptions{ReloadReadyTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed creating router: %v", err)
+	}
+	if err := router.Reload(); err != nil {
+		t.Fatalf("Failed reloading: %v", err)
+	}
+
+	for i := range old {
+		old[i].AssertCalled(t, "Terminate")
+		replaced[i].AssertCalled(t, "WaitReady", mock.AnythingOfType("time.Time"))
+	}
+	for i := 0; i < 2; i++ {
+		if resp, _ := router.Delegate(&funky.Request{}); resp.Payload != "new" {
+			t.Errorf("Expected a replaced server to be invoked, got %v", resp.Payload)
+		}
+	}
+}
+
+func TestReloadWaitsForBusyServer(t *testing.T) {
+	release := make(chan struct{})
+	busy := newBlockingServer(funky.FirstPort, "old", release)
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", funky.FirstPort).Return(busy, nil).Once()
+	serverFactory.On("CreateServer", funky.FirstPort).Return(newMockServer(funky.FirstPort, "new", nil), nil).Once()
+
+	router, _ := funky.NewRouter(1, serverFactory)
+
+	invoked := make(chan *funky.Message)
+	go func() {
+		resp, _ := router.Delegate(&funky.Request{})
+		invoked <- resp
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	var reloaded int32
+	go func() {
+		router.Reload()
+		atomic.StoreInt32(&reloaded, 1)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&reloaded) != 0 {
+		t.Fatal("Expected the reload to wait for the invocation in flight")
+	}
+	busy.AssertNotCalled(t, "Terminate")
+
+	close(release)
+	if resp := <-invoked; resp.Context.Error != nil || resp.Payload != "old" {
+		t.Errorf("Expected the invocation in flight to complete, got %+v", resp.Context.Error)
+	}
+	if resp, _ := router.Delegate(&funky.Request{}); resp.Payload != "new" {
+		t.Errorf("Expected the replaced server to be invoked, got %v", resp.Payload)
+	}
+	busy.AssertCalled(t, "Terminate")
+}
+
+func TestWatchDirectory(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "funky-watch")
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "lib"), 0755)
+
+	var changes int32
+	watcher, err := funky.WatchDirectory(dir, 50*time.Millisecond, func() {
+		atomic.AddInt32(&changes, 1)
+	})
+	if err != nil {
+		t.Fatalf("Failed watching %s: %v", dir, err)
+	}
+	defer watcher.Close()
+
+	ioutil.WriteFile(filepath.Join(dir, ".swap"), []byte("x"), 0644)
+	os.Mkdir(filepath.Join(dir, "__pycache__"), 0755)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&changes); n != 0 {
+		t.Errorf("Expected changes to ignored files not to count, got %d", n)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "main.py"), []byte("x"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "lib", "util.py"), []byte("x"), 0644)
+	time.Sleep(150 * time.Millisecond)
+	if n := atomic.LoadInt32(&changes); n != 1 {
+		t.Errorf("Expected a single change after the writes settled, got %d", n)
+	}
+}
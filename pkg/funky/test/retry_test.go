@@ -0,0 +1,66 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func newRetryRouter(t *testing.T, retry funky.RetryOptions, first, second *mocks.Server) funky.Router {
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(second, nil)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort+1)).Return(first, nil)
+
+	router, err := funky.NewRouterWithOptions(2, serverFactory, funky.RouterOptions{Retry: retry})
+	if err != nil {
+		t.Fatalf("Failed creating router: %v", err)
+	}
+	return router
+}
+
+func TestDelegateRetriesOnAnotherServer(t *testing.T) {
+	failing := newMockServer(funky.FirstPort+1, "ok", funky.ConnectionRefusedError("127.0.0.1:9001"))
+	working := newMockServer(funky.FirstPort, "ok", nil)
+	router := newRetryRouter(t, funky.RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond}, failing, working)
+
+	resp, err := router.Delegate(&funky.Request{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Context.Error != nil || resp.Payload != "ok" || resp.Context.Attempts != 2 {
+		t.Errorf("Expected success on the second attempt, got %+v", resp.Context)
+	}
+	failing.AssertNumberOfCalls(t, "Invoke", 1)
+	working.AssertNumberOfCalls(t, "Invoke", 1)
+}
+
+func TestDelegateDoesNotRetryAfterFunctionRan(t *testing.T) {
+	failing := newMockServer(funky.FirstPort+1, "ok", funky.InvocationError("connection reset"))
+	working := newMockServer(funky.FirstPort, "ok", nil)
+	router := newRetryRouter(t, funky.RetryOptions{MaxAttempts: 3}, failing, working)
+
+	resp, _ := router.Delegate(&funky.Request{})
+	if resp.Context.Error == nil || resp.Context.Attempts != 0 {
+		t.Errorf("Expected a single failed attempt, got %+v", resp.Context)
+	}
+	working.AssertNotCalled(t, "Invoke", mock.Anything)
+}
+
+func TestDelegateRetriesWithinDeadline(t *testing.T) {
+	failing := newMockServer(funky.FirstPort+1, "ok", funky.ConnectionRefusedError("127.0.0.1:9001"))
+	alsoFailing := newMockServer(funky.FirstPort, "ok", funky.ConnectionRefusedError("127.0.0.1:9000"))
+	router := newRetryRouter(t, funky.RetryOptions{MaxAttempts: 5, Backoff: 40 * time.Millisecond}, failing, alsoFailing)
+
+	deadline := time.Now().Add(100 * time.Millisecond).Format(time.RFC3339Nano)
+	resp, _ := router.Delegate(&funky.Request{Context: map[string]interface{}{"deadline": deadline}})
+	if resp.Context.Error == nil || resp.Context.Attempts != 2 {
+		t.Errorf("Expected 2 attempts within the deadline, got %+v", resp.Context)
+	}
+}
@@ -6,7 +6,9 @@ package test
 
 import (
 	"errors"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/dispatchframework/funky/pkg/funky"
 	"github.com/dispatchframework/funky/pkg/funky/mocks"
@@ -72,9 +74,10 @@ func TestDelegateSuccess(t *testing.T) {
 	server.On("Start").Return(nil)
 	server.On("IsIdle").Return(true)
 	server.On("SetIdle", mock.AnythingOfType("bool")).Return().Return()
-	server.On("Invoke", &funky.Request{}).Return(nil, nil)
+	server.On("Invoke", mock.AnythingOfType("*funky.Request")).Return(nil, nil)
 	server.On("Stdout").Return([]string{})
 	server.On("Stderr").Return([]string{})
+	server.On("Truncated").Return(0)
 
 	serverFactory := new(mocks.ServerFactory)
 	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(server, nil)
@@ -88,6 +91,66 @@ func TestDelegateSuccess(t *testing.T) {
 	}
 }
 
+func TestDelegateInvocationID(t *testing.T) {
+	hasID := func(r *funky.Request) bool {
+		return r.Context[funky.InvocationIDContextKey] == "abc"
+	}
+
+	server := new(mocks.Server)
+	server.On("Start").Return(nil)
+	server.On("Invoke", mock.MatchedBy(hasID)).Return(nil, nil)
+	server.On("Stdout").Return([]string{})
+	server.On("Stderr").Return([]string{})
+	server.On("Truncated").Return(0)
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(server, nil)
+
+	router, _ := funky.NewRouter(1, serverFactory)
+
+	resp, err := router.Delegate(&funky.Request{
+		Context: map[string]interface{}{funky.InvocationIDContextKey: "abc"},
+	})
+	if err != nil {
+		t.Fatalf("Received unexpected error calling Delegate: %+v", err)
+	}
+
+	if resp.Context.InvocationID != "abc" {
+		t.Errorf("Expected invocation ID abc in response context, got %q", resp.Context.InvocationID)
+	}
+}
+
+func TestForwardInvocationIDAndTimeout(t *testing.T) {
+	hasIDAndDeadline := func(r *funky.Request) bool {
+		_, ok := r.Context["deadline"].(string)
+		return r.Context[funky.InvocationIDContextKey] == "abc" && ok
+	}
+
+	server := new(mocks.Server)
+	server.On("Start").Return(nil)
+	server.On("Forward", mock.MatchedBy(hasIDAndDeadline), mock.Anything, mock.Anything).Return(nil)
+	server.On("Stdout").Return([]string{})
+	server.On("Stderr").Return([]string{})
+	server.On("Truncated").Return(0)
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(server, nil)
+
+	router, _ := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{ProxyTimeout: time.Second})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(funky.InvocationIDHeader, "abc")
+	resp, err := router.Forward(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("Received unexpected error calling Forward: %+v", err)
+	}
+
+	if resp.Context.InvocationID != "abc" || resp.Context.Error != nil {
+		t.Errorf("Unexpected response context %+v", resp.Context)
+	}
+	server.AssertExpectations(t)
+}
+
 func TestRouterShutdownSuccess(t *testing.T) {
 	server := new(mocks.Server)
 	server.On("Start").Return(nil)
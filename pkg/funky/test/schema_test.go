@@ -0,0 +1,135 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+const personSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"tags": {"type": "array", "items": {"enum": ["a", "b"]}, "uniqueItems": true},
+		"address": {"$ref": "#/definitions/address"}
+	},
+	"additionalProperties": false,
+	"definitions": {
+		"address": {
+			"type": "object",
+			"properties": {"street": {"type": "string"}, "next": {"$ref": "#/definitions/address"}}
+		}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := funky.ParseSchema([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing schema: %v", err)
+	}
+
+	tests := []struct {
+		payload    string
+		violations []string
+	}{
+		{`{"name": "Jon", "age": 30, "tags": ["a"], "address": {"street": "Main", "next": {"street": "Side"}}}`, nil},
+		{`{"age": 30}`, []string{"/name: is required"}},
+		{`{"name": "", "age": 1.5}`, []string{"/age: expected integer, got number", "/name: must be at least 1 characters long"}},
+		{`{"name": "Jon", "age": -1, "email": "nope"}`, []string{"/age: must be >= 0", "/email: must match the pattern ^[^@]+@[^@]+$"}},
+		{`{"name": "Jon", "age": 1, "tags": ["a", "c", "a"]}`, []string{"/tags: items must be unique, item 2 is a duplicate", `/tags/1: must be one of "a", "b"`}},
+		{`{"name": "Jon", "age": 1, "extra": true}`, []string{"/extra: no value is allowed"}},
+		{`{"name": "Jon", "age": 1, "address": {"next": {"street": 1}}}`, []string{"/address/next/street: expected string, got integer"}},
+		{`"Jon"`, []string{"/: expected object, got string"}},
+	}
+
+	for _, test := range tests {
+		var payload interface{}
+		json.Unmarshal([]byte(test.payload), &payload)
+
+		err := schema.Validate(payload)
+		if test.violations == nil {
+			if err != nil {
+				t.Errorf("Expected %s to be valid, got %v", test.payload, err)
+			}
+			continue
+		}
+
+		violations, ok := err.(funky.SchemaError)
+		if !ok || len(violations) != len(test.violations) {
+			t.Errorf("Expected violations %v for %s, got %v", test.violations, test.payload, err)
+			continue
+		}
+		for i, v := range violations {
+			if msg := (funky.SchemaError{v}).Error(); msg != test.violations[i] {
+				t.Errorf("Expected violation %q for %s, got %q", test.violations[i], test.payload, msg)
+			}
+		}
+	}
+}
+
+func TestParseSchemaInvalid(t *testing.T) {
+	for _, doc := range []string{`{`, `42`, `{"pattern": "("}`, `{"$ref": "http://example.com/schema"}`, `{"$ref": "#/missing"}`} {
+		if _, err := funky.ParseSchema([]byte(doc)); err == nil {
+			t.Errorf("Expected an error parsing %s", doc)
+		}
+	}
+}
+
+func TestParseSchemaUnsupportedKeywords(t *testing.T) {
+	for _, doc := range []string{
+		`{"type": "string", "format": "email"}`,
+		`{"dependencies": {"card": ["billing"]}}`,
+		`{"if": {"required": ["a"]}, "then": {"required": ["b"]}, "else": {"required": ["c"]}}`,
+		`{"properties": {"tags": {"contains": {"const": "a"}}}}`,
+		`{"anyOf": [{"propertyNames": {"pattern": "^a"}}]}`,
+	} {
+		_, err := funky.ParseSchema([]byte(doc))
+		if _, ok := err.(funky.IllegalArgumentError); !ok {
+			t.Errorf("Expected an IllegalArgumentError parsing %s, got %v", doc, err)
+		}
+	}
+
+	doc := `{"$schema": "http://json-schema.org/draft-07/schema#", "title": "Person", "default": {}, "properties": {"format": {"type": "string"}}}`
+	if _, err := funky.ParseSchema([]byte(doc)); err != nil {
+		t.Errorf("Unexpected error parsing schema with annotations: %v", err)
+	}
+}
+
+func TestValidatingRouter(t *testing.T) {
+	input, _ := funky.ParseSchema([]byte(`{"type": "string"}`))
+	output, _ := funky.ParseSchema([]byte(`{"type": "number"}`))
+
+	router := new(mocks.Router)
+	router.On("Delegate", mock.MatchedBy(func(r *funky.Request) bool { return r.Payload == "ok" })).
+		Return(&funky.Message{Context: &funky.Context{}, Payload: 42.0}, nil)
+	router.On("Delegate", mock.MatchedBy(func(r *funky.Request) bool { return r.Payload == "bad output" })).
+		Return(&funky.Message{Context: &funky.Context{}, Payload: "42"}, nil)
+
+	validating := funky.NewValidatingRouter(router, input, output)
+
+	resp, _ := validating.Delegate(&funky.Request{Payload: 1.0})
+	if resp.Context.Error == nil || resp.Context.Error.ErrorType != funky.InputError {
+		t.Errorf("Expected InputError, got %+v", resp.Context.Error)
+	}
+	router.AssertNotCalled(t, "Delegate", mock.MatchedBy(func(r *funky.Request) bool { return r.Payload == 1.0 }))
+
+	resp, _ = validating.Delegate(&funky.Request{Payload: "ok"})
+	if resp.Context.Error != nil || resp.Payload != 42.0 {
+		t.Errorf("Expected valid response, got %+v %v", resp.Context.Error, resp.Payload)
+	}
+
+	resp, _ = validating.Delegate(&funky.Request{Payload: "bad output"})
+	if resp.Context.Error == nil || resp.Context.Error.ErrorType != funky.FunctionError || resp.Payload != nil {
+		t.Errorf("Expected FunctionError without payload, got %+v %v", resp.Context.Error, resp.Payload)
+	}
+}
@@ -0,0 +1,162 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEnvironmentFilter(t *testing.T) {
+	env := []string{"PATH=/bin", "HOME=/root", "AWS_SECRET_ACCESS_KEY=xyz", "AWS_REGION=us-west-2", "LANG=C"}
+
+	filter := funky.EnvironmentFilter{
+		Allow: []string{"PATH", "HOME", "AWS_*"},
+		Deny:  []string{"AWS_SECRET_*"},
+	}
+
+	filtered := filter.Filter(env)
+	expected := []string{"PATH=/bin", "HOME=/root", "AWS_REGION=us-west-2"}
+	if !reflect.DeepEqual(filtered, expected) {
+		t.Errorf("Expected %v, got %v", expected, filtered)
+	}
+
+	if all := (funky.EnvironmentFilter{}).Filter(env); !reflect.DeepEqual(all, env) {
+		t.Errorf("Empty filter should keep every variable, got %v", all)
+	}
+}
+
+func TestSecretsFromDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "DB_PASSWORD"), []byte("hunter2\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "..data"), []byte("ignored"), 0600)
+
+	secrets, err := funky.NewSecrets(dir)
+	if err != nil {
+		t.Fatalf("Failed loading secrets: %+v", err)
+	}
+
+	expected := map[string]string{"DB_PASSWORD": "hunter2"}
+	if values := secrets.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "API_TOKEN"), []byte("abc"), 0600)
+	if err := secrets.Reload(); err != nil {
+		t.Fatalf("Failed reloading secrets: %+v", err)
+	}
+
+	if v := secrets.Values()["API_TOKEN"]; v != "abc" {
+		t.Errorf("Expected reloaded secret API_TOKEN, got %q", v)
+	}
+}
+
+func TestSecretsFromDotenv(t *testing.T) {
+	f, err := ioutil.TempFile("", "secrets.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("# comment\nexport TOKEN=\"abc def\"\nKEY='v=1'\n\nPLAIN=value\n")
+	f.Close()
+
+	secrets, err := funky.NewSecrets(f.Name())
+	if err != nil {
+		t.Fatalf("Failed loading secrets: %+v", err)
+	}
+
+	expected := map[string]string{"TOKEN": "abc def", "KEY": "v=1", "PLAIN": "value"}
+	if values := secrets.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
+
+func TestSecretsWatch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "secrets")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.env")
+	ioutil.WriteFile(path, []byte("API_TOKEN=abc\n"), 0600)
+
+	secrets, err := funky.NewSecrets(path)
+	if err != nil {
+		t.Fatalf("Failed loading secrets: %+v", err)
+	}
+
+	changed, stop := make(chan struct{}, 1), make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		secrets.Watch(10*time.Millisecond, stop, func() { changed <- struct{}{} })
+		close(stopped)
+	}()
+
+	ioutil.WriteFile(path, []byte("API_TOKEN=rotated\n"), 0600)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the change of the secrets to be reported")
+	}
+	if token := secrets.Values()["API_TOKEN"]; token != "rotated" {
+		t.Errorf("Expected the rotated secret, got %s", token)
+	}
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected Watch to return once stopped")
+	}
+}
+
+func TestDelegateInjectsSecrets(t *testing.T) {
+	f, err := ioutil.TempFile("", "secrets.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("TOKEN=abc\n")
+	f.Close()
+
+	secrets, _ := funky.NewSecrets(f.Name())
+
+	hasSecret := func(r *funky.Request) bool {
+		s, ok := r.Context[funky.SecretsContextKey].(map[string]interface{})
+		return ok && s["TOKEN"] == "abc"
+	}
+
+	server := new(mocks.Server)
+	server.On("Start").Return(nil)
+	server.On("Invoke", mock.MatchedBy(hasSecret)).Return(nil, nil)
+	server.On("Stdout").Return([]string{})
+	server.On("Stderr").Return([]string{})
+	server.On("Truncated").Return(0)
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(server, nil)
+
+	router, _ := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{Secrets: secrets})
+
+	req := &funky.Request{Context: map[string]interface{}{}}
+	if _, err := router.Delegate(req); err != nil {
+		t.Fatalf("Received unexpected error calling Delegate: %+v", err)
+	}
+
+	if _, ok := req.Context[funky.SecretsContextKey]; ok {
+		t.Error("Delegate should not modify the caller's request context")
+	}
+}
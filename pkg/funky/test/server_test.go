@@ -7,12 +7,17 @@ package test
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dispatchframework/funky/pkg/funky"
 )
@@ -171,3 +176,242 @@ func TestInvokeContextPassthrough(t *testing.T) {
 		t.Errorf("Result from invoke was not a map[string]interface{} like expected")
 	}
 }
+
+// waitForLines polls the server's stdout until it holds n lines or a second has passed
+func waitForLines(server funky.Server, n int) []string {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && len(server.Stdout()) < n {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return server.Stdout()
+}
+
+func TestServerLogLineLimit(t *testing.T) {
+	factory, _ := funky.NewDefaultServerFactoryWithOptions("seq 1 100", funky.ServerOptions{
+		LogLimits: funky.LogLimits{MaxLines: 10},
+	})
+	server, _ := factory.CreateServer(9090)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer server.Shutdown()
+
+	lines := waitForLines(server, 11)
+	expected := []string{"1", "2", "3", "4", "5", "... 90 lines truncated ...", "96", "97", "98", "99", "100"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+
+	if n := server.Truncated(); n != 90 {
+		t.Errorf("Expected 90 truncated lines, got %d", n)
+	}
+}
+
+func TestServerLogLineLengthLimit(t *testing.T) {
+	factory, _ := funky.NewDefaultServerFactoryWithOptions("printf %0100d\\n 0", funky.ServerOptions{
+		LogLimits: funky.LogLimits{MaxLineLength: 10},
+	})
+	server, _ := factory.CreateServer(9090)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer server.Shutdown()
+
+	lines := waitForLines(server, 1)
+	expected := []string{"0000000000 ... [90 bytes truncated]"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+}
+
+func TestServerLogByteLimit(t *testing.T) {
+	factory, _ := funky.NewDefaultServerFactoryWithOptions("printf %0100d\\n 0", funky.ServerOptions{
+		LogLimits: funky.LogLimits{MaxBytes: 20},
+	})
+	server, _ := factory.CreateServer(9090)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer server.Shutdown()
+
+	lines := waitForLines(server, 1)
+	expected := []string{"0000000000 ... [90 bytes truncated]"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+}
+
+func TestServerLogTruncationMarkerTime(t *testing.T) {
+	factory, _ := funky.NewDefaultServerFactoryWithOptions("seq 1 3", funky.ServerOptions{
+		LogLimits: funky.LogLimits{MaxLines: 1},
+	})
+	server, _ := factory.CreateServer(9090)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer server.Shutdown()
+
+	waitForLines(server, 2)
+	records := server.Records()
+	if len(records) != 2 || records[1].Message != "... 2 lines truncated ..." {
+		t.Fatalf("Expected the first line and a truncation marker, got %+v", records)
+	}
+	if records[1].Time.IsZero() {
+		t.Errorf("Expected the truncation marker to have the time of the truncated lines")
+	}
+}
+
+// slowSink a memorySink taking its time to write every line
+type slowSink struct {
+	memorySink
+}
+
+func (s *slowSink) Write(line *funky.LogLine) error {
+	time.Sleep(time.Millisecond)
+	return s.memorySink.Write(line)
+}
+
+func TestTerminateKeepsLastLines(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "funky-server")
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "server.sh")
+	ioutil.WriteFile(script, []byte("seq 1 200\nexec sleep 10\n"), 0644)
+
+	sink := &slowSink{}
+	factory, _ := funky.NewDefaultServerFactoryWithOptions("sh "+script, funky.ServerOptions{LogSink: sink})
+	server, _ := factory.CreateServer(9090)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if err := server.Terminate(); err != nil {
+		t.Fatalf("Failed to terminate server: %+v", err)
+	}
+	if n := len(sink.Lines()); n != 200 {
+		t.Errorf("Expected the 200 lines written before the server was terminated, got %d", n)
+	}
+}
+
+func TestInvokeStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept"); !strings.Contains(accept, funky.NDJSONContentType) {
+			t.Errorf("Expected the function server to be asked for NDJSON, got %q", accept)
+		}
+		w.Header().Set("Content-Type", funky.NDJSONContentType)
+		fmt.Fprint(w, "{\"token\":\"Hello\"}\n\n{\"token\":\"World\"}\n")
+	}))
+	defer ts.Close()
+
+	urlParts := strings.Split(ts.URL, ":")
+	port, _ := strconv.Atoi(urlParts[len(urlParts)-1])
+	server, err := funky.NewServer(uint16(port), exec.Command("echo"))
+	if err != nil {
+		t.Fatalf("Failed to create new server: %+v", err)
+	}
+
+	chunks := []string{}
+	err = server.InvokeStream(&funky.Request{Context: map[string]interface{}{}}, func(chunk json.RawMessage) error {
+		chunks = append(chunks, string(chunk))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to invoke function: %+v", err)
+	}
+
+	expected := []string{`{"token":"Hello"}`, `{"token":"World"}`}
+	if !reflect.DeepEqual(chunks, expected) {
+		t.Errorf("Expected chunks %v, got %v", expected, chunks)
+	}
+}
+
+func TestInvokeStreamPlainJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"myField": "Hello"}`)
+	}))
+	defer ts.Close()
+
+	urlParts := strings.Split(ts.URL, ":")
+	port, _ := strconv.Atoi(urlParts[len(urlParts)-1])
+	server, _ := funky.NewServer(uint16(port), exec.Command("echo"))
+
+	chunks := 0
+	err := server.InvokeStream(&funky.Request{Context: map[string]interface{}{}}, func(chunk json.RawMessage) error {
+		chunks++
+		return nil
+	})
+	if err != nil || chunks != 1 {
+		t.Errorf("Expected a single chunk, got %d chunks and error %v", chunks, err)
+	}
+}
+
+func TestInvokeRawPayload(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G'})
+	}))
+	defer ts.Close()
+
+	urlParts := strings.Split(ts.URL, ":")
+	port, _ := strconv.Atoi(urlParts[len(urlParts)-1])
+	server, _ := funky.NewServer(uint16(port), exec.Command("echo"))
+
+	result, err := server.Invoke(&funky.Request{Context: map[string]interface{}{}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	raw, ok := result.(*funky.RawPayload)
+	if !ok {
+		t.Fatalf("Expected a RawPayload, got %T", result)
+	}
+	if raw.ContentType != "image/png" || string(raw.Data) != "\x89PNG" {
+		t.Errorf("Unexpected raw payload %+v", raw)
+	}
+}
+
+func TestForward(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprintf(w, "%s %s", r.URL.RequestURI(), r.Header.Get("X-Custom"))
+	}))
+	defer ts.Close()
+
+	urlParts := strings.Split(ts.URL, ":")
+	port, _ := strconv.Atoi(urlParts[len(urlParts)-1])
+	server, _ := funky.NewServer(uint16(port), exec.Command("echo"))
+
+	r := httptest.NewRequest("DELETE", "/items/1?force=true", nil)
+	r.Header.Set("X-Custom", "value")
+	w := httptest.NewRecorder()
+	if err := server.Forward(&funky.Request{Context: map[string]interface{}{}}, w, r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if w.Code != http.StatusTeapot || w.Header().Get("X-Method") != "DELETE" {
+		t.Errorf("Unexpected response %d %v", w.Code, w.Header())
+	}
+	if w.Body.String() != "/items/1?force=true value" {
+		t.Errorf("Unexpected body %q", w.Body.String())
+	}
+}
+
+func TestForwardTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	urlParts := strings.Split(ts.URL, ":")
+	port, _ := strconv.Atoi(urlParts[len(urlParts)-1])
+	server, _ := funky.NewServer(uint16(port), exec.Command("echo"))
+
+	deadline := time.Now().Add(50 * time.Millisecond).Format(time.RFC3339Nano)
+	w := httptest.NewRecorder()
+	err := server.Forward(&funky.Request{Context: map[string]interface{}{"deadline": deadline}}, w, httptest.NewRequest("GET", "/", nil))
+	if _, ok := err.(funky.TimeoutError); !ok {
+		t.Errorf("Expected TimeoutError, got %v", err)
+	}
+}
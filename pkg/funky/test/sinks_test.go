@@ -0,0 +1,110 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+type memorySink struct {
+	lock  sync.Mutex
+	lines []funky.LogLine
+}
+
+func (s *memorySink) Write(line *funky.LogLine) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lines = append(s.lines, *line)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func (s *memorySink) Lines() []funky.LogLine {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]funky.LogLine{}, s.lines...)
+}
+
+func TestServerForwardsBackgroundLogsToSink(t *testing.T) {
+	sink := &memorySink{}
+	factory, _ := funky.NewDefaultServerFactoryWithOptions("seq 1 3", funky.ServerOptions{
+		LogSink: sink,
+	})
+	server, _ := factory.CreateServer(9090)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %+v", err)
+	}
+	defer server.Shutdown()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && len(sink.Lines()) < 3 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lines := sink.Lines()
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines in sink, got %v", lines)
+	}
+	for i, line := range lines {
+		if line.Port != 9090 || line.Stream != funky.StdoutStream || line.InvocationID != "" {
+			t.Errorf("Unexpected tags on background line: %+v", line)
+		}
+		if line.Text != []string{"1", "2", "3"}[i] {
+			t.Errorf("Unexpected text on line %d: %q", i, line.Text)
+		}
+	}
+}
+
+func TestRotatingFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "functions.log")
+	sink, err := funky.NewRotatingFileSink(path, 200, 2)
+	if err != nil {
+		t.Fatalf("Failed creating sink: %+v", err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(&funky.LogLine{InvocationID: "abc", Port: 9000, Stream: funky.StderrStream, Text: "some output"}); err != nil {
+			t.Fatalf("Failed writing to sink: %+v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Expected file %s: %+v", name, err)
+		}
+		if len(b) > 200 {
+			t.Errorf("File %s exceeds the size limit: %d bytes", name, len(b))
+		}
+
+		var line funky.LogLine
+		if err := json.Unmarshal([]byte(strings.SplitN(string(b), "\n", 2)[0]), &line); err != nil || line.InvocationID != "abc" {
+			t.Errorf("Unexpected content in %s: %s", name, b)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 backups")
+	}
+}
@@ -0,0 +1,77 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		err      *funky.Error
+		expected int
+	}{
+		{nil, http.StatusOK},
+		{&funky.Error{ErrorType: funky.InputError}, http.StatusBadRequest},
+		{&funky.Error{ErrorType: funky.FunctionError}, http.StatusUnprocessableEntity},
+		{&funky.Error{ErrorType: funky.SystemError}, http.StatusBadGateway},
+		{funky.NewErrorMessage(funky.FunctionError, funky.TimeoutError("slow")).Context.Error, http.StatusGatewayTimeout},
+		{funky.NewErrorMessage(funky.SystemError, funky.QueueFullError("full")).Context.Error, http.StatusTooManyRequests},
+		{funky.NewErrorMessage(funky.SystemError, funky.ConnectionRefusedError("url")).Context.Error, http.StatusServiceUnavailable},
+		{funky.NewErrorMessage(funky.SystemError, funky.CircuitOpenError("open")).Context.Error, http.StatusServiceUnavailable},
+		{funky.NewErrorMessage(funky.SystemError, funky.BadRequestError("deadline")).Context.Error, http.StatusBadRequest},
+		{funky.NewErrorMessage(funky.SystemError, errors.New("other")).Context.Error, http.StatusBadGateway},
+	}
+
+	for _, test := range tests {
+		if status := funky.StatusCode(test.err, http.StatusUnprocessableEntity); status != test.expected {
+			t.Errorf("StatusCode(%+v) = %d, expected %d", test.err, status, test.expected)
+		}
+	}
+}
+
+func TestDelegateMaxPending(t *testing.T) {
+	release := make(chan struct{})
+
+	server := new(mocks.Server)
+	server.On("Start").Return(nil)
+	server.On("Invoke", mock.AnythingOfType("*funky.Request")).Run(func(mock.Arguments) { <-release }).Return(nil, nil)
+	server.On("Stdout").Return([]string{})
+	server.On("Stderr").Return([]string{})
+	server.On("Truncated").Return(0)
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(server, nil)
+
+	router, _ := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{MaxPending: 1})
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := router.Delegate(&funky.Request{})
+			done <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	_, err := router.Delegate(&funky.Request{})
+	if _, ok := err.(funky.QueueFullError); !ok {
+		t.Errorf("Expected QueueFullError, got %v", err)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestInvocationStoreLifecycle(t *testing.T) {
+	store, err := funky.NewInvocationStore(time.Hour, 10, "")
+	if err != nil {
+		t.Fatalf("Failed creating store: %+v", err)
+	}
+
+	if _, err := store.Create("a"); err != nil {
+		t.Fatalf("Failed creating invocation: %+v", err)
+	}
+	if inv, ok := store.Get("a"); !ok || inv.Status != funky.InvocationPending {
+		t.Errorf("Expected pending invocation, got %+v", inv)
+	}
+
+	store.Complete("a", &funky.Message{Payload: "done"})
+	inv, ok := store.Get("a")
+	if !ok || inv.Status != funky.InvocationCompleted || inv.Result.Payload != "done" || inv.Finished == nil {
+		t.Errorf("Expected completed invocation, got %+v", inv)
+	}
+
+	if _, ok := store.Get("missing"); ok {
+		t.Error("Expected unknown invocation to be missing")
+	}
+}
+
+func TestInvocationStoreBounds(t *testing.T) {
+	store, _ := funky.NewInvocationStore(time.Hour, 2, "")
+
+	store.Create("a")
+	store.Create("b")
+	if _, err := store.Create("c"); err == nil {
+		t.Fatal("Expected QueueFullError when the store is full of pending invocations")
+	} else if _, ok := err.(funky.QueueFullError); !ok {
+		t.Errorf("Expected QueueFullError, got %+v", err)
+	}
+
+	store.Complete("a", &funky.Message{})
+	if _, err := store.Create("c"); err != nil {
+		t.Fatalf("Expected the completed invocation to be evicted: %+v", err)
+	}
+	if _, ok := store.Get("a"); ok {
+		t.Error("Expected invocation a to be evicted")
+	}
+}
+
+func TestInvocationStoreExpiry(t *testing.T) {
+	store, _ := funky.NewInvocationStore(10*time.Millisecond, 10, "")
+
+	store.Create("a")
+	store.Complete("a", &funky.Message{})
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := store.Get("a"); ok {
+		t.Error("Expected completed invocation to expire")
+	}
+}
+
+func TestInvocationStoreJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "invocations.journal")
+
+	store, err := funky.NewInvocationStore(time.Hour, 10, path)
+	if err != nil {
+		t.Fatalf("Failed creating store: %+v", err)
+	}
+	store.Create("done")
+	store.Complete("done", &funky.Message{Payload: "result"})
+	store.Create("running")
+	store.Close()
+
+	restored, err := funky.NewInvocationStore(time.Hour, 10, path)
+	if err != nil {
+		t.Fatalf("Failed restoring store: %+v", err)
+	}
+	defer restored.Close()
+
+	if inv, ok := restored.Get("done"); !ok || inv.Status != funky.InvocationCompleted || inv.Result.Payload != "result" {
+		t.Errorf("Expected completed invocation to be restored, got %+v", inv)
+	}
+	if inv, ok := restored.Get("running"); !ok || inv.Status != funky.InvocationLost || inv.Result.Context.Error == nil {
+		t.Errorf("Expected pending invocation to be restored as lost, got %+v", inv)
+	}
+}
+
+func TestAsyncInvokerSubmit(t *testing.T) {
+	router := new(mocks.Router)
+	router.On("Delegate", mock.AnythingOfType("*funky.Request")).Return(&funky.Message{Payload: "ok"}, nil)
+
+	store, _ := funky.NewInvocationStore(time.Hour, 10, "")
+	async := funky.NewAsyncInvoker(router, store, nil)
+
+	inv, err := async.Submit(&funky.Request{}, nil)
+	if err != nil {
+		t.Fatalf("Failed submitting invocation: %+v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if done, _ := async.Get(inv.ID); done.Status == funky.InvocationCompleted {
+			if done.Result.Payload != "ok" {
+				t.Errorf("Unexpected result %+v", done.Result)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Invocation did not complete")
+}
@@ -0,0 +1,83 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func isWarmUp(r *funky.Request) bool {
+	return r.Context[funky.WarmUpContextKey] == true
+}
+
+func TestWarmUpWaitsForServer(t *testing.T) {
+	server := new(mocks.Server)
+	server.On("Start").Return(nil)
+	server.On("GetPort").Return(funky.FirstPort)
+	server.On("Invoke", mock.MatchedBy(isWarmUp)).Return(nil, funky.ConnectionRefusedError("127.0.0.1:9000")).Once()
+	server.On("Invoke", mock.MatchedBy(isWarmUp)).Return("warm", nil).Twice()
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(server, nil)
+
+	_, err := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{
+		WarmUp: funky.WarmUpOptions{Payloads: []interface{}{"first", "second"}, Timeout: time.Second},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating router: %v", err)
+	}
+	server.AssertNumberOfCalls(t, "Invoke", 3)
+	server.AssertCalled(t, "Invoke", mock.MatchedBy(func(r *funky.Request) bool { return r.Payload == "second" }))
+}
+
+func TestWarmUpReplacesFailingServer(t *testing.T) {
+	failing := new(mocks.Server)
+	failing.On("Start").Return(nil)
+	failing.On("GetPort").Return(funky.FirstPort)
+	failing.On("Invoke", mock.MatchedBy(isWarmUp)).Return(nil, funky.FunctionServerError{})
+	failing.On("Terminate").Return(nil)
+
+	warm := new(mocks.Server)
+	warm.On("Start").Return(nil)
+	warm.On("Invoke", mock.MatchedBy(isWarmUp)).Return("warm", nil)
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(failing, nil).Once()
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(warm, nil).Once()
+
+	_, err := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{
+		WarmUp: funky.WarmUpOptions{Payloads: []interface{}{"warm up"}, MaxAttempts: 2},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating router: %v", err)
+	}
+	failing.AssertCalled(t, "Terminate")
+	warm.AssertNumberOfCalls(t, "Invoke", 1)
+}
+
+func TestWarmUpFailure(t *testing.T) {
+	server := new(mocks.Server)
+	server.On("Start").Return(nil)
+	server.On("GetPort").Return(funky.FirstPort)
+	server.On("Invoke", mock.MatchedBy(isWarmUp)).Return(nil, errors.New("import failed"))
+	server.On("Terminate").Return(nil)
+
+	serverFactory := new(mocks.ServerFactory)
+	serverFactory.On("CreateServer", uint16(funky.FirstPort)).Return(server, nil)
+
+	_, err := funky.NewRouterWithOptions(1, serverFactory, funky.RouterOptions{
+		WarmUp: funky.WarmUpOptions{Payloads: []interface{}{"warm up"}, MaxAttempts: 3},
+	})
+	if err == nil {
+		t.Fatal("Expected the router to fail when servers cannot be warmed up")
+	}
+	serverFactory.AssertNumberOfCalls(t, "CreateServer", 3)
+}
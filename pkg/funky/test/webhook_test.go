@@ -0,0 +1,174 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+// loopback allows callbacks to the test servers
+var loopback = []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+
+func TestWebhookSignedDeliveryWithRetry(t *testing.T) {
+	secret := []byte("s3cr3t")
+	var attempts int32
+	received := make(chan funky.Message, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp := r.Header.Get(funky.TimestampHeader)
+		if sig := r.Header.Get(funky.SignatureHeader); timestamp == "" || sig != funky.Sign(secret, timestamp, body) {
+			t.Errorf("Invalid signature %q of timestamp %q", sig, timestamp)
+		}
+		if id := r.Header.Get(funky.InvocationIDHeader); id != "abc" {
+			t.Errorf("Expected invocation ID header abc, got %q", id)
+		}
+
+		var msg funky.Message
+		json.Unmarshal(body, &msg)
+		received <- msg
+	}))
+	defer ts.Close()
+
+	notifier := funky.NewWebhookNotifier(secret, 3, time.Millisecond, time.Second, 10, loopback)
+	notifier.Notify(ts.URL, "abc", &funky.Message{Payload: "result"})
+
+	select {
+	case msg := <-received:
+		if msg.Payload != "result" {
+			t.Errorf("Unexpected payload %v", msg.Payload)
+		}
+	default:
+		t.Fatal("Callback was not delivered")
+	}
+
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("Expected 2 attempts, got %d", n)
+	}
+	if failed := notifier.Failed(); len(failed) != 0 {
+		t.Errorf("Expected no failed deliveries, got %+v", failed)
+	}
+}
+
+func TestWebhookFailedDelivery(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	notifier := funky.NewWebhookNotifier(nil, 3, time.Millisecond, time.Second, 10, loopback)
+	notifier.Notify(ts.URL, "abc", &funky.Message{})
+
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	failed := notifier.Failed()
+	if len(failed) != 1 || failed[0].InvocationID != "abc" || failed[0].Attempts != 3 || failed[0].URL != ts.URL {
+		t.Errorf("Expected the failed delivery to be kept, got %+v", failed)
+	}
+}
+
+func TestWebhookClientErrorNotRetried(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	notifier := funky.NewWebhookNotifier(nil, 3, time.Millisecond, time.Second, 10, loopback)
+	notifier.Notify(ts.URL, "abc", &funky.Message{})
+
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("Expected a single attempt, got %d", n)
+	}
+}
+
+func TestWebhookSignatureCoversTimestamp(t *testing.T) {
+	secret, body := []byte("s3cr3t"), []byte(`{"payload":"result"}`)
+	if funky.Sign(secret, "1500000000", body) == funky.Sign(secret, "1500000001", body) {
+		t.Error("Expected the signature to change with the timestamp")
+	}
+}
+
+func TestWebhookRejectsInternalAddresses(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+	}))
+	defer ts.Close()
+
+	notifier := funky.NewWebhookNotifier(nil, 1, time.Millisecond, time.Second, 10, nil)
+
+	tests := []struct {
+		callback string
+		valid    bool
+	}{
+		{"http://127.0.0.1:8080/hook", false},
+		{"http://10.1.2.3/hook", false},
+		{"http://192.168.0.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://[::1]/hook", false},
+		{"http://0.0.0.0/hook", false},
+		{"ftp://example.com/hook", false},
+		{"http://93.184.216.34/hook", true},
+	}
+	for _, test := range tests {
+		err := notifier.ValidateCallback(test.callback)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("ValidateCallback(%q) = %v, expected valid %v", test.callback, err, test.valid)
+		}
+	}
+
+	notifier.Notify(ts.URL, "abc", &funky.Message{})
+	if n := atomic.LoadInt32(&attempts); n != 0 {
+		t.Errorf("Expected no connection to a loopback callback, got %d", n)
+	}
+	if failed := notifier.Failed(); len(failed) != 1 {
+		t.Errorf("Expected the refused delivery to be kept, got %+v", failed)
+	}
+
+	allowed := funky.NewWebhookNotifier(nil, 1, time.Millisecond, time.Second, 10, loopback)
+	if err := allowed.ValidateCallback(ts.URL); err != nil {
+		t.Errorf("Expected an allowed network to be accepted, got %v", err)
+	}
+}
+
+func TestWebhookRedirectNotFollowed(t *testing.T) {
+	var followed int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&followed, 1)
+	}))
+	defer target.Close()
+	ts := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer ts.Close()
+
+	notifier := funky.NewWebhookNotifier(nil, 3, time.Millisecond, time.Second, 10, loopback)
+	notifier.Notify(ts.URL, "abc", &funky.Message{})
+
+	if n := atomic.LoadInt32(&followed); n != 0 {
+		t.Errorf("Expected the redirect not to be followed, got %d requests", n)
+	}
+	if failed := notifier.Failed(); len(failed) != 1 || failed[0].Attempts != 1 {
+		t.Errorf("Expected a single failed attempt, got %+v", failed)
+	}
+}
@@ -0,0 +1,87 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"fmt"
+	"time"
+)
+
+// WarmUpContextKey the Request.Context key set to true in warm-up invocations
+const WarmUpContextKey = "warmUp"
+
+const (
+	// serverPollInterval the wait between attempts to reach a server that is not listening yet
+	serverPollInterval = 50 * time.Millisecond
+	// defaultStartTimeout bounds warm-up invocations if WarmUpOptions.Timeout is not positive, and waiting for
+	// servers started on demand by invocations without a deadline
+	defaultStartTimeout = 30 * time.Second
+)
+
+// WarmUpOptions invocations sent to every new server before it receives requests
+type WarmUpOptions struct {
+	// Payloads are invoked in order on every new server. Servers are not warmed up if empty.
+	Payloads []interface{}
+	// Timeout bounds each warm-up invocation, including the wait for the server to start listening. Defaults to 30s.
+	Timeout time.Duration
+	// MaxAttempts the number of servers started in turn until one is warmed up, at least 1
+	MaxAttempts int
+}
+
+// startServer creates and starts a server on port and warms it up, replacing it with a new server if that fails
+func startServer(serverFactory ServerFactory, port uint16, warmUp WarmUpOptions) (Server, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var server Server
+		server, err = serverFactory.CreateServer(port)
+		if err != nil {
+			return nil, fmt.Errorf("Failed creating server on port %d", port)
+		}
+		if err = server.Start(); err != nil {
+			err = fmt.Errorf("Failed to start server %+v", err)
+		} else if err = warmUpServer(server, warmUp); err == nil {
+			return server, nil
+		} else {
+			server.Terminate()
+		}
+
+		if attempt >= warmUp.MaxAttempts {
+			return nil, err
+		}
+	}
+}
+
+// warmUpServer invokes the warm-up payloads on server, waiting for it to start listening
+func warmUpServer(server Server, warmUp WarmUpOptions) error {
+	timeout := warmUp.Timeout
+	if timeout <= 0 {
+		timeout = defaultStartTimeout
+	}
+
+	for _, payload := range warmUp.Payloads {
+		deadline := time.Now().Add(timeout)
+		input := &Request{
+			Context: map[string]interface{}{
+				"deadline":             deadline.Format(time.RFC3339Nano),
+				InvocationIDContextKey: NewInvocationID(),
+				WarmUpContextKey:       true,
+			},
+			Payload: payload,
+		}
+
+		for {
+			_, err := server.Invoke(input)
+			if err == nil {
+				break
+			}
+			if _, ok := err.(ConnectionRefusedError); !ok || time.Now().Add(serverPollInterval).After(deadline) {
+				return fmt.Errorf("Failed to warm up server on port %d: %s", server.GetPort(), err)
+			}
+			time.Sleep(serverPollInterval)
+		}
+	}
+
+	return nil
+}
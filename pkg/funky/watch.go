@@ -0,0 +1,51 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirWatcher calls a function when files in a directory tree change, once the changes have settled
+type DirWatcher struct {
+	changed  func()
+	debounce time.Duration
+
+	lock  sync.Mutex
+	timer *time.Timer
+	close func() error
+}
+
+// notify schedules a call of changed, postponing a scheduled call that is still pending
+func (w *DirWatcher) notify() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.debounce, w.changed)
+	} else {
+		w.timer.Reset(w.debounce)
+	}
+}
+
+// Close stops watching
+func (w *DirWatcher) Close() error {
+	w.lock.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.lock.Unlock()
+
+	return w.close()
+}
+
+// ignoredPath returns whether changes to the file or directory with the given name are ignored: hidden files,
+// such as those of version control, editor backups, and files that servers write as they import code
+func ignoredPath(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+		name == "__pycache__" || strings.HasSuffix(name, ".pyc")
+}
@@ -0,0 +1,95 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+
+//go:build linux
+// +build linux
+
+package funky
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// WatchDirectory calls changed once files in dir or its subdirectories changed and no further changes followed
+// for the debounce duration. Changes to ignored files, such as hidden ones, do not count.
+func WatchDirectory(dir string, debounce time.Duration, changed func()) (*DirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// a non-blocking file is read through the runtime poller, so that closing it ends a pending read
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	dirs := map[int32]string{}
+	add := func(path string) error {
+		wd, err := syscall.InotifyAddWatch(fd, path, watchMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		dirs[int32(wd)] = path
+		return nil
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir && ignoredPath(info.Name()) {
+			return filepath.SkipDir
+		}
+		return add(path)
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &DirWatcher{
+		changed:  changed,
+		debounce: debounce,
+		close:    file.Close,
+	}
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+				offset = nameStart + int(event.Len)
+
+				if name != "" && ignoredPath(name) {
+					continue
+				}
+				// watch directories created after the watch started
+				if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					if parent, ok := dirs[event.Wd]; ok {
+						add(filepath.Join(parent, name))
+					}
+				}
+				w.notify()
+			}
+		}
+	}()
+
+	return w, nil
+}
@@ -0,0 +1,18 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+
+//go:build !linux
+// +build !linux
+
+package funky
+
+import (
+	"time"
+)
+
+// WatchDirectory is only supported on Linux
+func WatchDirectory(dir string, debounce time.Duration, changed func()) (*DirWatcher, error) {
+	return nil, IllegalArgumentError("watching directories is only supported on Linux")
+}
@@ -0,0 +1,214 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package funky
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CallbackContextKey the Request.Context key holding the URL notified when an asynchronous invocation completes
+const CallbackContextKey = "callback"
+
+// Headers set on callback requests
+const (
+	SignatureHeader    = "X-Funky-Signature"
+	TimestampHeader    = "X-Funky-Timestamp"
+	InvocationIDHeader = "X-Funky-Invocation-Id"
+)
+
+// FailedDelivery a struct to hold a callback that could not be delivered
+type FailedDelivery struct {
+	URL          string          `json:"url"`
+	InvocationID string          `json:"invocationId"`
+	Attempts     int             `json:"attempts"`
+	LastError    string          `json:"lastError"`
+	Time         time.Time       `json:"time"`
+	Body         json.RawMessage `json:"body"`
+}
+
+// WebhookNotifier a struct to POST the results of invocations to callback URLs, retrying with exponential backoff
+type WebhookNotifier struct {
+	client      *http.Client
+	secret      []byte
+	maxAttempts int
+	backoff     time.Duration
+	maxFailed   int
+	allowed     []*net.IPNet
+
+	lock   sync.Mutex
+	failed []FailedDelivery
+}
+
+// NewWebhookNotifier returns a WebhookNotifier making up to maxAttempts attempts per callback, waiting backoff after
+// the first failure and twice as long after each further one. Bodies are signed with secret if not empty.
+// The last maxFailed undeliverable callbacks are kept for inspection. Callbacks to loopback, private and link-local
+// addresses are refused unless they are in one of the allowed networks, and redirects are not followed.
+func NewWebhookNotifier(secret []byte, maxAttempts int, backoff time.Duration, timeout time.Duration, maxFailed int, allowed []*net.IPNet) *WebhookNotifier {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	n := &WebhookNotifier{
+		secret:      secret,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		maxFailed:   maxFailed,
+		allowed:     allowed,
+	}
+
+	// the address is checked again once resolved for the connection, so that a host cannot be pointed elsewhere
+	// after the callback was validated
+	dialer := &net.Dialer{Timeout: timeout, Control: n.checkDial}
+	n.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return n
+}
+
+// Sign returns the signature sent in the SignatureHeader: "sha256=" followed by the hex encoded HMAC-SHA256 of
+// timestamp, a dot and body, timestamp being the value of the TimestampHeader
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateCallback checks that callback is an absolute http or https URL whose host resolves to allowed addresses only
+func (n *WebhookNotifier) ValidateCallback(callback string) error {
+	u, err := url.Parse(callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return BadRequestError(fmt.Sprintf("invalid callback URL %q", callback))
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return BadRequestError(fmt.Sprintf("unable to resolve callback host %q", u.Hostname()))
+	}
+	for _, ip := range ips {
+		if !n.permitted(ip) {
+			return BadRequestError(fmt.Sprintf("callback URL %q resolves to the disallowed address %s", callback, ip))
+		}
+	}
+	return nil
+}
+
+// permitted returns whether callbacks may connect to ip
+func (n *WebhookNotifier) permitted(ip net.IP) bool {
+	for _, network := range n.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// checkDial refuses connections to addresses that are not permitted
+func (n *WebhookNotifier) checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !n.permitted(ip) {
+		return fmt.Errorf("callback address %s is not allowed", host)
+	}
+	return nil
+}
+
+// Notify POSTs msg to callback, blocking until it is delivered or all attempts failed
+func (n *WebhookNotifier) Notify(callback string, id string, msg *Message) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		n.recordFailure(callback, id, 0, err, nil)
+		return
+	}
+
+	delay := n.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.deliver(callback, id, body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt >= n.maxAttempts {
+			n.recordFailure(callback, id, attempt, err, body)
+			return
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// deliver makes a single attempt, returning whether a failure is worth retrying
+func (n *WebhookNotifier) deliver(callback string, id string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(InvocationIDHeader, id)
+	if len(n.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, Sign(n.secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Failed returns the callbacks that could not be delivered, oldest first
+func (n *WebhookNotifier) Failed() []FailedDelivery {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return append([]FailedDelivery{}, n.failed...)
+}
+
+func (n *WebhookNotifier) recordFailure(callback string, id string, attempts int, err error, body []byte) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.failed = append(n.failed, FailedDelivery{
+		URL:          callback,
+		InvocationID: id,
+		Attempts:     attempts,
+		LastError:    err.Error(),
+		Time:         time.Now().UTC(),
+		Body:         body,
+	})
+	if len(n.failed) > n.maxFailed {
+		n.failed = n.failed[len(n.failed)-n.maxFailed:]
+	}
+}
@@ -0,0 +1,82 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+)
+
+const (
+	// proxyLogsPath the path of the logs of passed through requests on the admin API
+	proxyLogsPath   = "/logs/"
+	proxyLogsHeader = "X-Funky-Logs"
+)
+
+// proxyHandler passes requests as-is to function servers, keeping the logs of every invocation for proxyLogsPath
+// of the admin API
+type proxyHandler struct {
+	router funky.Router
+	logs   *funky.InvocationStore
+}
+
+func (h proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(funky.InvocationIDHeader)
+	if id == "" {
+		id = funky.NewInvocationID()
+		r.Header.Set(funky.InvocationIDHeader, id)
+	}
+	w.Header().Set(funky.InvocationIDHeader, id)
+	if h.logs != nil {
+		w.Header().Set(proxyLogsHeader, proxyLogsPath+id)
+	}
+
+	pw := &proxyResponseWriter{ResponseWriter: w}
+	resp, err := h.router.Forward(pw, r)
+	if err != nil {
+		resp = funky.NewErrorMessage(funky.SystemError, err)
+	}
+
+	if h.logs != nil {
+		if _, err := h.logs.Create(id); err == nil {
+			h.logs.Complete(id, resp)
+		}
+	}
+
+	if resp.Context.Error == nil {
+		return
+	}
+	if pw.wroteHeader {
+		// the response is incomplete, make sure the client can tell
+		panic(http.ErrAbortHandler)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(funky.StatusCode(resp.Context.Error, http.StatusBadGateway))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// proxyResponseWriter records whether the function server's response was started
+type proxyResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *proxyResponseWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *proxyResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets the proxy flush the underlying ResponseWriter
+func (w *proxyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
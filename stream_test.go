@@ -0,0 +1,53 @@
+///////////////////////////////////////////////////////////////////////
+// Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+///////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dispatchframework/funky/pkg/funky"
+	"github.com/dispatchframework/funky/pkg/funky/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStreamStatusCode(t *testing.T) {
+	failure := funky.NewErrorMessage(funky.InputError, errors.New("bad input"))
+
+	tests := []struct {
+		chunks []string
+		status int
+	}{
+		{nil, http.StatusBadRequest},
+		{[]string{`"partial"`}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		chunks := test.chunks
+		router := new(mocks.Router)
+		router.On("DelegateStream", mock.Anything, mock.Anything).Return(
+			func(_ *funky.Request, chunk func(json.RawMessage) error) *funky.Message {
+				for _, c := range chunks {
+					chunk(json.RawMessage(c))
+				}
+				return failure
+			}, nil)
+
+		h := funkyHandler{router: router, statusCodes: true, functionErrorStatus: http.StatusInternalServerError}
+		w := httptest.NewRecorder()
+		h.stream(w, &funky.Request{})
+
+		if w.Code != test.status {
+			t.Errorf("Expected status %d after %d chunks, got %d", test.status, len(chunks), w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "bad input") {
+			t.Errorf("Expected the error in the context line, got %q", w.Body.String())
+		}
+	}
+}